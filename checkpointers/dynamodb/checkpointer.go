@@ -0,0 +1,353 @@
+package dynamodbcheckpointer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/remind101/kinesumer/checkpointers/inflight"
+	"github.com/remind101/kinesumer/dynamodbclient"
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/loggers/text"
+	"github.com/remind101/kinesumer/metrics/empty"
+)
+
+// Schema names the attributes that checkpoints are stored in.
+type Schema struct {
+	// Key is the table's string hash key, which holds the shard ID.
+	Key string
+	// Checkpoint holds the sequence number of the last record that was processed.
+	Checkpoint string
+	// KCL is set if the table is a Java KCL lease table, whose other attributes are kept
+	// consistent with each checkpoint.
+	KCL bool
+}
+
+var (
+	DefaultSchema = Schema{Key: "shardId", Checkpoint: "sequenceNumber"}
+
+	// KCLSchema is the layout of the Java KCL's lease table, so that an application can move
+	// between the KCL and kinesumer without losing its position in the stream.
+	KCLSchema = Schema{Key: "leaseKey", Checkpoint: "checkpoint", KCL: true}
+)
+
+// The KCL initializes a lease's checkpoint to the iterator type it starts reading the shard from.
+var kclSentinels = map[string]bool{
+	"TRIM_HORIZON": true,
+	"LATEST":       true,
+	"AT_TIMESTAMP": true,
+}
+
+type Checkpointer struct {
+	heads       map[string]string
+	dirty       map[string]bool
+	inFlight    *inflight.Tracker
+	c           chan k.Record
+	syncC       chan chan struct{}
+	stopped     chan struct{}
+	mut         sync.Mutex
+	db          k.DynamoDB
+	table       string
+	schema      Schema
+	leaseOwner  string
+	createTable bool
+	savePeriod  time.Duration
+	wg          sync.WaitGroup
+	errHandler  func(k.Error)
+	readOnly    bool
+	metrics     k.Metrics
+}
+
+type Options struct {
+	ReadOnly   bool
+	SavePeriod time.Duration
+	DynamoDB   k.DynamoDB
+	Table      string
+
+	// The zero value is DefaultSchema.
+	Schema Schema
+
+	// If LeaseOwner is set, a checkpoint is only saved while the shard's lease in the table is
+	// held by this owner, as the KCL does.
+	LeaseOwner string
+
+	// If CreateTable is set, Begin creates the table if it doesn't exist.
+	CreateTable bool
+
+	// ErrHandler is passed errors saving and loading checkpoints. The zero value logs them to
+	// Logger.
+	ErrHandler func(k.Error)
+
+	// Logger is what the default ErrHandler logs to. The zero value prints to stdout.
+	Logger k.Logger
+
+	// Metrics is told how long each checkpoint write took. The zero value discards it.
+	Metrics k.Metrics
+}
+
+type Error struct {
+	origin   error
+	severity string
+	// Key/value pairs describing where the error occurred, such as the shard.
+	fields []interface{}
+}
+
+func (e *Error) Severity() string { return e.severity }
+
+func (e *Error) Origin() error { return e.origin }
+
+func (e *Error) Error() string { return e.origin.Error() }
+
+func (e *Error) Fields() []interface{} { return e.fields }
+
+func New(opt *Options) (*Checkpointer, error) {
+	if opt.DynamoDB == nil {
+		return nil, errors.New("DynamoDB client must not be nil")
+	}
+	if len(opt.Table) == 0 {
+		return nil, errors.New("Table name can't be empty")
+	}
+
+	save := opt.SavePeriod
+	if save == 0 {
+		save = 5 * time.Second
+	}
+
+	schema := opt.Schema
+	if len(schema.Key) == 0 {
+		schema = DefaultSchema
+	}
+
+	if opt.Logger == nil {
+		opt.Logger = textlogger.New(os.Stdout)
+	}
+
+	if opt.ErrHandler == nil {
+		logger := opt.Logger
+		opt.ErrHandler = func(err k.Error) {
+			k.LogError(logger, err)
+		}
+	}
+
+	if opt.Metrics == nil {
+		opt.Metrics = emptymetrics.Metrics{}
+	}
+
+	// The checkpointer saves in the caller's goroutine until RunCheckpointer is started.
+	stopped := make(chan struct{})
+	close(stopped)
+
+	return &Checkpointer{
+		heads:       make(map[string]string),
+		dirty:       make(map[string]bool),
+		inFlight:    inflight.New(),
+		c:           make(chan k.Record),
+		syncC:       make(chan chan struct{}),
+		stopped:     stopped,
+		db:          opt.DynamoDB,
+		table:       opt.Table,
+		schema:      schema,
+		leaseOwner:  opt.LeaseOwner,
+		createTable: opt.CreateTable,
+		savePeriod:  save,
+		errHandler:  opt.ErrHandler,
+		readOnly:    opt.ReadOnly,
+		metrics:     opt.Metrics,
+	}, nil
+}
+
+// Track registers a record as in flight. A shard's head only advances to the highest sequence
+// number below which every tracked record has been marked done.
+func (d *Checkpointer) Track(record k.Record) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.inFlight.Track(k.CheckpointKey(record), record.LeaseEpoch(), record.ExtendedSequenceNumber())
+}
+
+// Revoke stops a shard whose lease was lost from being checkpointed until it is tracked under a
+// later lease, and drops its unsaved head.
+func (d *Checkpointer) Revoke(shardID string, epoch int64) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.inFlight.Revoke(shardID, epoch)
+	delete(d.heads, shardID)
+	delete(d.dirty, shardID)
+}
+
+func (d *Checkpointer) DoneC() chan<- k.Record {
+	return d.c
+}
+
+// Sync saves the heads that have advanced since they were last saved, including those of records
+// that were sent to DoneC before Sync was called. A head that fails to save is retried on the next
+// Sync, unless the write's condition failed because the shard's lease was taken by another owner
+// or the shard has already been checkpointed as ended.
+func (d *Checkpointer) Sync() {
+	// While RunCheckpointer is running, it saves the heads once it has applied the records it
+	// has been sent.
+	synced := make(chan struct{})
+	select {
+	case d.syncC <- synced:
+		<-synced
+	case <-d.stopped:
+		d.saveHeads()
+	}
+}
+
+func (d *Checkpointer) saveHeads() {
+	if d.readOnly {
+		return
+	}
+
+	d.mut.Lock()
+	heads := make(map[string]string, len(d.dirty))
+	for shardID := range d.dirty {
+		heads[shardID] = d.heads[shardID]
+	}
+	d.dirty = make(map[string]bool)
+	d.mut.Unlock()
+
+	for shardID, sequence := range heads {
+		start := time.Now()
+		err := d.save(context.Background(), shardID, sequence)
+		d.metrics.CheckpointSaved(time.Since(start), err)
+		if err == nil {
+			continue
+		}
+
+		if dynamodbclient.IsConditionalCheckFailed(err) {
+			d.errHandler(&Error{fmt.Errorf("Checkpoint for %s not saved, its lease is held by another owner or it has ended: %v", shardID, err), k.EWarn, d.fields(shardID)})
+			continue
+		}
+
+		d.errHandler(&Error{fmt.Errorf("Could not save checkpoint for %s: %v", shardID, err), k.EWarn, d.fields(shardID)})
+		d.mut.Lock()
+		if d.heads[shardID] == sequence {
+			d.dirty[shardID] = true
+		}
+		d.mut.Unlock()
+	}
+}
+
+// save writes a checkpoint. A shard that has been checkpointed as ended is never moved back, and
+// if there is a lease owner the checkpoint is only written while it holds the lease.
+func (d *Checkpointer) save(ctx context.Context, shardID, sequence string) error {
+	update := "SET #checkpoint = :checkpoint"
+	condition := "(attribute_not_exists(#checkpoint) OR #checkpoint <> :shardEnd)"
+	names := map[string]*string{"#checkpoint": aws.String(d.schema.Checkpoint)}
+	values := map[string]*dynamodbclient.AttributeValue{
+		":checkpoint": dynamodbclient.S(sequence),
+		":shardEnd":   dynamodbclient.S(k.ShardEnd),
+	}
+
+	if d.schema.KCL {
+		// The KCL keeps the sub-sequence number of an aggregated user record in its own attribute.
+		sequenceNumber, subSequenceNumber, _ := k.SplitSequenceNumber(sequence)
+		values[":checkpoint"] = dynamodbclient.S(sequenceNumber)
+		update += ", checkpointSubSequenceNumber = :subSequence, ownerSwitchesSinceCheckpoint = :zero"
+		values[":subSequence"] = dynamodbclient.N(subSequenceNumber)
+		values[":zero"] = dynamodbclient.N(0)
+	}
+	if len(d.leaseOwner) > 0 {
+		condition += " AND leaseOwner = :owner"
+		values[":owner"] = dynamodbclient.S(d.leaseOwner)
+	}
+
+	_, err := d.db.UpdateItem(ctx, &dynamodbclient.UpdateItemInput{
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		Key:                       d.key(shardID),
+		TableName:                 aws.String(d.table),
+		UpdateExpression:          aws.String(update),
+	})
+	return err
+}
+
+// fields describes the checkpoint of a shard in errors.
+func (d *Checkpointer) fields(shardID string) []interface{} {
+	return []interface{}{"table", d.table, "shard", shardID}
+}
+
+func (d *Checkpointer) key(shardID string) map[string]*dynamodbclient.AttributeValue {
+	return map[string]*dynamodbclient.AttributeValue{d.schema.Key: dynamodbclient.S(shardID)}
+}
+
+func (d *Checkpointer) RunCheckpointer() {
+	defer d.wg.Done()
+	defer close(d.stopped)
+	saveTicker := time.NewTicker(d.savePeriod).C
+loop:
+	for {
+		select {
+		case <-saveTicker:
+			d.saveHeads()
+		case synced := <-d.syncC:
+			d.saveHeads()
+			close(synced)
+		case state, ok := <-d.c:
+			if !ok {
+				break loop
+			}
+			d.mut.Lock()
+			key := k.CheckpointKey(state)
+			if head, ok := d.inFlight.Done(key, state.LeaseEpoch(), state.ExtendedSequenceNumber()); ok {
+				d.heads[key] = head
+				d.dirty[key] = true
+			}
+			d.mut.Unlock()
+		}
+	}
+	d.saveHeads()
+}
+
+func (d *Checkpointer) Begin(ctx context.Context) error {
+	if d.createTable {
+		if err := dynamodbclient.EnsureTable(ctx, d.db, d.table, d.schema.Key); err != nil {
+			return &Error{err, k.ECrit, []interface{}{"table", d.table}}
+		}
+	}
+
+	d.stopped = make(chan struct{})
+	d.wg.Add(1)
+	go d.RunCheckpointer()
+	return nil
+}
+
+func (d *Checkpointer) End() {
+	close(d.c)
+	d.wg.Wait()
+}
+
+// GetStartSequence returns the shard's checkpoint, or "" if it has none. A KCL lease that hasn't
+// been checkpointed yet also returns "", so that the shard is read from the default iterator type.
+// An error is returned if the checkpoint couldn't be read.
+func (d *Checkpointer) GetStartSequence(ctx context.Context, shardID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	out, err := d.db.GetItem(ctx, &dynamodbclient.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            d.key(shardID),
+		TableName:      aws.String(d.table),
+	})
+	if err != nil {
+		return "", &Error{fmt.Errorf("Could not get checkpoint for %s: %v", shardID, err), k.EError, d.fields(shardID)}
+	}
+
+	seq := dynamodbclient.StringValue(out.Item[d.schema.Checkpoint])
+	if d.schema.KCL && kclSentinels[seq] {
+		return "", nil
+	}
+	// The KCL checkpoints records that weren't aggregated with a sub-sequence number of 0 too, so
+	// the record is read again and skipped if it wasn't aggregated.
+	if sub, ok := out.Item["checkpointSubSequenceNumber"]; ok && d.schema.KCL && len(seq) > 0 && seq != k.ShardEnd {
+		return k.JoinSequenceNumber(seq, dynamodbclient.Int64Value(sub)), nil
+	}
+	return seq, nil
+}
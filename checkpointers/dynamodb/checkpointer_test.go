@@ -0,0 +1,321 @@
+package dynamodbcheckpointer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"github.com/pborman/uuid"
+	"github.com/remind101/kinesumer/dynamodbclient"
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeCheckpointer(schema Schema) (*Checkpointer, *mocks.DynamoDB, chan k.Error) {
+	db := new(mocks.DynamoDB)
+	errs := make(chan k.Error, 10)
+	d, _ := New(&Options{
+		SavePeriod: time.Hour,
+		DynamoDB:   db,
+		Table:      "checkpoints",
+		Schema:     schema,
+		ErrHandler: func(err k.Error) { errs <- err },
+	})
+	return d, db, errs
+}
+
+func TestCheckpointerSync(t *testing.T) {
+	d, db, _ := makeCheckpointer(Schema{})
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(&dynamodbclient.UpdateItemOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	})
+
+	d.Begin(context.Background())
+	d.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: "1001"}
+	d.End()
+
+	db.AssertNumberOfCalls(t, "UpdateItem", 1)
+	assert.Equal(t, "checkpoints", aws.ToString(input.TableName))
+	assert.Equal(t, "shard1", dynamodbclient.StringValue(input.Key["shardId"]))
+	assert.Equal(t, "sequenceNumber", aws.ToString(input.ExpressionAttributeNames["#checkpoint"]))
+	assert.Equal(t, "1001", dynamodbclient.StringValue(input.ExpressionAttributeValues[":checkpoint"]))
+
+	// Heads that haven't moved aren't saved again.
+	d.Sync()
+	db.AssertNumberOfCalls(t, "UpdateItem", 1)
+}
+
+func TestCheckpointerSyncAfterDone(t *testing.T) {
+	d, db, _ := makeCheckpointer(Schema{})
+
+	var saved []string
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(&dynamodbclient.UpdateItemOutput{}, nil).Run(func(args mock.Arguments) {
+		input := args.Get(1).(*dynamodbclient.UpdateItemInput)
+		saved = append(saved, dynamodbclient.StringValue(input.ExpressionAttributeValues[":checkpoint"]))
+	})
+
+	// A record sent to DoneC is saved by the next Sync, even though RunCheckpointer may not have
+	// applied it yet when the send returns.
+	d.Begin(context.Background())
+	for i := 1001; i < 1101; i++ {
+		sequence := strconv.Itoa(i)
+		d.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: sequence}
+		d.Sync()
+		assert.Equal(t, sequence, saved[len(saved)-1])
+	}
+	d.End()
+	assert.Len(t, saved, 100)
+}
+
+func TestCheckpointerSyncKCL(t *testing.T) {
+	d, db, _ := makeCheckpointer(KCLSchema)
+	d.leaseOwner = "worker1"
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(&dynamodbclient.UpdateItemOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	})
+
+	d.Begin(context.Background())
+	d.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: "1001"}
+	d.End()
+
+	assert.Equal(t, "shard1", dynamodbclient.StringValue(input.Key["leaseKey"]))
+	assert.Equal(t, "checkpoint", aws.ToString(input.ExpressionAttributeNames["#checkpoint"]))
+	assert.Contains(t, aws.ToString(input.UpdateExpression), "checkpointSubSequenceNumber = :subSequence")
+	assert.Equal(t, "1001", dynamodbclient.StringValue(input.ExpressionAttributeValues[":checkpoint"]))
+	assert.Equal(t, int64(0), dynamodbclient.Int64Value(input.ExpressionAttributeValues[":subSequence"]))
+	assert.Contains(t, aws.ToString(input.ConditionExpression), "leaseOwner = :owner")
+	assert.Equal(t, "worker1", dynamodbclient.StringValue(input.ExpressionAttributeValues[":owner"]))
+}
+
+func TestCheckpointerSyncKCLAggregated(t *testing.T) {
+	d, db, _ := makeCheckpointer(KCLSchema)
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(&dynamodbclient.UpdateItemOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	})
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{
+		Item: map[string]*dynamodbclient.AttributeValue{
+			"leaseKey":                    dynamodbclient.S("shard1"),
+			"checkpoint":                  dynamodbclient.S("1001"),
+			"checkpointSubSequenceNumber": dynamodbclient.N(2),
+		},
+	}, nil)
+
+	d.Begin(context.Background())
+	d.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: "1001:2"}
+	d.End()
+
+	assert.Equal(t, "1001", dynamodbclient.StringValue(input.ExpressionAttributeValues[":checkpoint"]))
+	assert.Equal(t, int64(2), dynamodbclient.Int64Value(input.ExpressionAttributeValues[":subSequence"]))
+	assertStartSequence(t, d, "shard1", "1001:2")
+}
+
+func TestCheckpointerSyncFailure(t *testing.T) {
+	d, db, errs := makeCheckpointer(Schema{})
+	d.heads = map[string]string{"shard1": "1001"}
+	d.dirty = map[string]bool{"shard1": true}
+
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(nil, &smithy.GenericAPIError{Code: "InternalServerError", Message: "bad"}).Once()
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(nil, &smithy.GenericAPIError{Code: "ConditionalCheckFailedException", Message: "The conditional request failed"}).Once()
+
+	// A failed save is retried on the next Sync.
+	d.Sync()
+	assert.Equal(t, k.EWarn, (<-errs).Severity())
+	assert.Equal(t, map[string]bool{"shard1": true}, d.dirty)
+
+	// A lost lease isn't.
+	d.Sync()
+	assert.Equal(t, k.EWarn, (<-errs).Severity())
+	assert.Equal(t, map[string]bool{}, d.dirty)
+}
+
+func TestCheckpointerRevoke(t *testing.T) {
+	d, db, _ := makeCheckpointer(Schema{})
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(&dynamodbclient.UpdateItemOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	})
+
+	d.Begin(context.Background())
+	records := []*FakeRecord{
+		{shardId: "shard1", sequenceNumber: "1001", leaseEpoch: 1},
+		{shardId: "shard1", sequenceNumber: "1002", leaseEpoch: 1},
+	}
+	for _, record := range records {
+		d.Track(record)
+	}
+	d.DoneC() <- records[0]
+	d.Revoke("shard1", 1)
+	d.DoneC() <- records[1]
+	d.Sync()
+	db.AssertNotCalled(t, "UpdateItem", mock.Anything, mock.Anything)
+
+	// The shard is acquired again under a later lease.
+	record := &FakeRecord{shardId: "shard1", sequenceNumber: "1003", leaseEpoch: 2}
+	d.Track(record)
+	d.DoneC() <- record
+	d.End()
+	db.AssertNumberOfCalls(t, "UpdateItem", 1)
+	assert.Equal(t, "1003", dynamodbclient.StringValue(input.ExpressionAttributeValues[":checkpoint"]))
+}
+
+func TestCheckpointerGetStartSequence(t *testing.T) {
+	d, db, _ := makeCheckpointer(KCLSchema)
+
+	var input *dynamodbclient.GetItemInput
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{
+		Item: map[string]*dynamodbclient.AttributeValue{
+			"leaseKey":   dynamodbclient.S("shard1"),
+			"checkpoint": dynamodbclient.S("1000"),
+		},
+	}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.GetItemInput)
+	}).Once()
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{
+		Item: map[string]*dynamodbclient.AttributeValue{
+			"leaseKey":   dynamodbclient.S("shard2"),
+			"checkpoint": dynamodbclient.S("TRIM_HORIZON"),
+		},
+	}, nil).Once()
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{}, nil).Once()
+
+	assertStartSequence(t, d, "shard1", "1000")
+	assert.Equal(t, "shard1", dynamodbclient.StringValue(input.Key["leaseKey"]))
+	assert.True(t, aws.ToBool(input.ConsistentRead))
+	assertStartSequence(t, d, "shard2", "")
+	assertStartSequence(t, d, "shard3", "")
+}
+
+func TestCheckpointerGetStartSequenceError(t *testing.T) {
+	d, db, _ := makeCheckpointer(KCLSchema)
+
+	db.On("GetItem", mock.Anything, mock.Anything).Return(nil, errors.New("throttled"))
+
+	// A checkpoint that can't be read must not be mistaken for a shard that has none, which would
+	// be read from LATEST.
+	seq, err := d.GetStartSequence(context.Background(), "shard1")
+	assert.Error(t, err)
+	assert.Equal(t, "", seq)
+}
+
+func assertStartSequence(t *testing.T, d *Checkpointer, shardID, expected string) {
+	seq, err := d.GetStartSequence(context.Background(), shardID)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, seq)
+}
+
+// TestCheckpointerDynamoDBLocal runs against DynamoDB Local when DYNAMODB_ENDPOINT is set, e.g. to
+// http://localhost:8000.
+func TestCheckpointerDynamoDBLocal(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT is not set")
+	}
+
+	db := dynamodbclient.New(aws.Config{
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		Region:      "us-east-1",
+	}, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	table := "kinesumer-test-" + uuid.New()
+	newCheckpointer := func() *Checkpointer {
+		d, err := New(&Options{
+			SavePeriod:  time.Hour,
+			DynamoDB:    db,
+			Table:       table,
+			Schema:      KCLSchema,
+			CreateTable: true,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, d.Begin(context.Background()))
+		return d
+	}
+
+	d := newCheckpointer()
+	d.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: "1001"}
+	d.DoneC() <- &FakeRecord{shardId: "shard2", sequenceNumber: k.ShardEnd}
+	d.End()
+
+	d = newCheckpointer()
+	assertStartSequence(t, d, "shard1", "1001")
+	assertStartSequence(t, d, "shard2", k.ShardEnd)
+
+	// An ended shard can't be moved back.
+	d.DoneC() <- &FakeRecord{shardId: "shard2", sequenceNumber: "2001"}
+	d.End()
+	assertStartSequence(t, d, "shard2", k.ShardEnd)
+}
+
+type FakeRecord struct {
+	sequenceNumber string
+	shardId        string
+	leaseEpoch     int64
+}
+
+func (r *FakeRecord) Data() []byte {
+	return nil
+}
+
+func (r *FakeRecord) PartitionKey() string {
+	return ""
+}
+
+func (r *FakeRecord) SequenceNumber() string {
+	return r.sequenceNumber
+}
+
+func (r *FakeRecord) SubSequenceNumber() int64 {
+	_, sub, _ := k.SplitSequenceNumber(r.sequenceNumber)
+	return sub
+}
+
+func (r *FakeRecord) ExtendedSequenceNumber() string {
+	return r.sequenceNumber
+}
+
+func (r *FakeRecord) ShardId() string {
+	return r.shardId
+}
+
+func (r *FakeRecord) StreamName() string {
+	return ""
+}
+
+func (r *FakeRecord) MillisBehindLatest() int64 {
+	return -1
+}
+
+func (r *FakeRecord) ApproximateArrivalTimestamp() time.Time {
+	return time.Time{}
+}
+
+func (r *FakeRecord) EncryptionType() string {
+	return ""
+}
+
+func (r *FakeRecord) ExplicitHashKey() string {
+	return ""
+}
+
+func (r *FakeRecord) LeaseEpoch() int64 {
+	return r.leaseEpoch
+}
+
+func (r *FakeRecord) Done() {
+}
@@ -1,25 +1,30 @@
 package emptycheckpointer
 
 import (
+	"context"
+
 	k "github.com/remind101/kinesumer/interface"
 )
 
 type Checkpointer struct {
 }
 
+func (p Checkpointer) Track(k.Record) {
+}
+
 func (p Checkpointer) DoneC() chan<- k.Record {
 	return nil
 }
 
-func (p Checkpointer) Begin() error {
+func (p Checkpointer) Begin(context.Context) error {
 	return nil
 }
 
 func (p Checkpointer) End() {
 }
 
-func (p Checkpointer) GetStartSequence(string) string {
-	return ""
+func (p Checkpointer) GetStartSequence(context.Context, string) (string, error) {
+	return "", nil
 }
 
 func (p Checkpointer) Sync() {
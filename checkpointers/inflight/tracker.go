@@ -0,0 +1,132 @@
+// Package inflight tracks the records of each shard that have been handed to a consumer but not
+// yet marked done, so that a checkpointer only advances a shard's checkpoint to the highest
+// sequence number below which every record has been processed.
+package inflight
+
+import (
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// Tracker tracks in flight records by shard. It isn't safe for concurrent use.
+type Tracker struct {
+	shards map[string]*shard
+	// revoked holds the latest lease epoch of each shard whose lease was lost.
+	revoked map[string]int64
+}
+
+type shard struct {
+	// epoch is the lease epoch that the records were read under.
+	epoch int64
+	// queue holds the in flight records in the order they were tracked, which is the order of
+	// their sequence numbers.
+	queue   []*entry
+	entries map[string]*entry
+}
+
+type entry struct {
+	sequenceNumber string
+	done           bool
+}
+
+func New() *Tracker {
+	return &Tracker{
+		shards:  make(map[string]*shard),
+		revoked: make(map[string]int64),
+	}
+}
+
+// Track registers a record read under a lease epoch as in flight. Records must be tracked in the
+// order they are read from the shard. Tracking a record at or before the last tracked record, or
+// under a later lease, means that the shard is being read again, so the records that were in
+// flight are forgotten. Records of a lease that was revoked or superseded are ignored.
+func (t *Tracker) Track(shardID string, epoch int64, sequenceNumber string) {
+	s := t.shards[shardID]
+	if t.fenced(shardID, epoch) {
+		return
+	}
+	if s == nil || epoch > s.epoch || len(s.queue) > 0 && !Less(s.queue[len(s.queue)-1].sequenceNumber, sequenceNumber) {
+		s = &shard{epoch: epoch, entries: make(map[string]*entry)}
+		t.shards[shardID] = s
+	}
+
+	e := &entry{sequenceNumber: sequenceNumber}
+	s.queue = append(s.queue, e)
+	s.entries[sequenceNumber] = e
+}
+
+// Done marks a record as done. It returns the sequence number that the shard's checkpoint can be
+// advanced to, and false if the checkpoint can't be advanced yet because an earlier record is
+// still in flight, or the record's lease was revoked or superseded. Records that were never
+// tracked advance the checkpoint as long as nothing is in flight on their shard.
+func (t *Tracker) Done(shardID string, epoch int64, sequenceNumber string) (string, bool) {
+	if t.fenced(shardID, epoch) {
+		return "", false
+	}
+	s := t.shards[shardID]
+	if s == nil || len(s.queue) == 0 {
+		return sequenceNumber, true
+	}
+
+	e := s.entries[sequenceNumber]
+	if e == nil {
+		return "", false
+	}
+	e.done = true
+
+	var head string
+	for len(s.queue) > 0 && s.queue[0].done {
+		head = s.queue[0].sequenceNumber
+		delete(s.entries, head)
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+	}
+	if len(s.queue) == 0 {
+		delete(t.shards, shardID)
+	}
+
+	return head, len(head) > 0
+}
+
+// Revoke forgets the records in flight on a shard whose lease was lost, and fences off the records
+// of that lease and any earlier one, so that they can't advance the shard's checkpoint.
+func (t *Tracker) Revoke(shardID string, epoch int64) {
+	if epoch > t.revoked[shardID] {
+		t.revoked[shardID] = epoch
+	}
+	if s := t.shards[shardID]; s != nil && s.epoch <= epoch {
+		delete(t.shards, shardID)
+	}
+}
+
+// fenced reports whether records of a lease epoch may no longer advance the shard's checkpoint.
+func (t *Tracker) fenced(shardID string, epoch int64) bool {
+	if revoked, ok := t.revoked[shardID]; ok && epoch <= revoked {
+		return true
+	}
+	s := t.shards[shardID]
+	return s != nil && epoch < s.epoch
+}
+
+// InFlight returns the number of records in flight on a shard.
+func (t *Tracker) InFlight(shardID string) int {
+	if s := t.shards[shardID]; s != nil {
+		return len(s.queue)
+	}
+	return 0
+}
+
+// Less reports whether extended sequence number a comes before b. Sequence numbers are decimal
+// integers too large for an int64, so they're compared by length and then lexically. The user
+// records aggregated into a Kinesis record come after any record before it, in the order of their
+// sub-sequence numbers.
+func Less(a, b string) bool {
+	a, aSub, aAggregated := k.SplitSequenceNumber(a)
+	b, bSub, bAggregated := k.SplitSequenceNumber(b)
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	if a != b {
+		return a < b
+	}
+	return aAggregated && bAggregated && aSub < bSub
+}
@@ -0,0 +1,130 @@
+package inflight
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackerInOrder(t *testing.T) {
+	tr := New()
+	tr.Track("shard0", 0, "1")
+	tr.Track("shard0", 0, "2")
+
+	head, ok := tr.Done("shard0", 0, "1")
+	assert.True(t, ok)
+	assert.Equal(t, "1", head)
+
+	head, ok = tr.Done("shard0", 0, "2")
+	assert.True(t, ok)
+	assert.Equal(t, "2", head)
+	assert.Equal(t, 0, tr.InFlight("shard0"))
+}
+
+func TestTrackerOutOfOrder(t *testing.T) {
+	tr := New()
+	tr.Track("shard0", 0, "7")
+	tr.Track("shard0", 0, "9")
+	tr.Track("shard0", 0, "10")
+	tr.Track("shard1", 0, "8")
+
+	_, ok := tr.Done("shard0", 0, "10")
+	assert.False(t, ok)
+	_, ok = tr.Done("shard0", 0, "9")
+	assert.False(t, ok)
+
+	head, ok := tr.Done("shard1", 0, "8")
+	assert.True(t, ok)
+	assert.Equal(t, "8", head)
+
+	head, ok = tr.Done("shard0", 0, "7")
+	assert.True(t, ok)
+	assert.Equal(t, "10", head)
+	assert.Equal(t, 0, tr.InFlight("shard0"))
+}
+
+func TestTrackerUntracked(t *testing.T) {
+	tr := New()
+	head, ok := tr.Done("shard0", 0, "5")
+	assert.True(t, ok)
+	assert.Equal(t, "5", head)
+
+	tr.Track("shard0", 0, "6")
+	_, ok = tr.Done("shard0", 0, "5")
+	assert.False(t, ok)
+	assert.Equal(t, 1, tr.InFlight("shard0"))
+}
+
+func TestTrackerRewind(t *testing.T) {
+	tr := New()
+	tr.Track("shard0", 0, "7")
+	tr.Track("shard0", 0, "8")
+
+	// The shard is read again from 7, so the old records in flight are forgotten.
+	tr.Track("shard0", 0, "7")
+	assert.Equal(t, 1, tr.InFlight("shard0"))
+
+	_, ok := tr.Done("shard0", 0, "8")
+	assert.False(t, ok)
+	head, ok := tr.Done("shard0", 0, "7")
+	assert.True(t, ok)
+	assert.Equal(t, "7", head)
+}
+
+func TestTrackerAggregated(t *testing.T) {
+	tr := New()
+	tr.Track("shard0", 0, "7:0")
+	tr.Track("shard0", 0, "7:1")
+	tr.Track("shard0", 0, "8")
+	assert.Equal(t, 3, tr.InFlight("shard0"))
+
+	_, ok := tr.Done("shard0", 0, "7:1")
+	assert.False(t, ok)
+	head, ok := tr.Done("shard0", 0, "7:0")
+	assert.True(t, ok)
+	assert.Equal(t, "7:1", head)
+}
+
+func TestTrackerRevoke(t *testing.T) {
+	tr := New()
+	tr.Track("shard0", 1, "1")
+	tr.Track("shard0", 1, "2")
+	tr.Revoke("shard0", 1)
+	assert.Equal(t, 0, tr.InFlight("shard0"))
+
+	// Records of the lost lease can't advance the checkpoint, even once nothing is in flight.
+	_, ok := tr.Done("shard0", 1, "1")
+	assert.False(t, ok)
+
+	// The shard is acquired again under a later lease.
+	tr.Track("shard0", 2, "1")
+	_, ok = tr.Done("shard0", 1, "1")
+	assert.False(t, ok)
+	head, ok := tr.Done("shard0", 2, "1")
+	assert.True(t, ok)
+	assert.Equal(t, "1", head)
+}
+
+func TestTrackerLaterLease(t *testing.T) {
+	tr := New()
+	tr.Track("shard0", 1, "5")
+	tr.Track("shard0", 2, "8")
+	assert.Equal(t, 1, tr.InFlight("shard0"))
+
+	_, ok := tr.Done("shard0", 1, "5")
+	assert.False(t, ok)
+	head, ok := tr.Done("shard0", 2, "8")
+	assert.True(t, ok)
+	assert.Equal(t, "8", head)
+}
+
+func TestLess(t *testing.T) {
+	assert.True(t, Less("9", "10"))
+	assert.True(t, Less("49590338271490256608559692538361571095921575989136588898", "49590338271490256608559692540925702759324208523137515618"))
+	assert.False(t, Less("10", "9"))
+	assert.False(t, Less("10", "10"))
+	assert.True(t, Less("9:3", "10:0"))
+	assert.True(t, Less("10:1", "10:2"))
+	assert.False(t, Less("10:2", "10:1"))
+	assert.False(t, Less("10:2", "10:2"))
+}
@@ -1,26 +1,37 @@
 package redischeckpointer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/remind101/kinesumer/checkpointers/inflight"
 	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/loggers/text"
+	"github.com/remind101/kinesumer/metrics/empty"
 )
 
 type Checkpointer struct {
 	heads       map[string]string
+	inFlight    *inflight.Tracker
 	c           chan k.Record
+	syncC       chan chan struct{}
+	stopped     chan struct{}
 	mut         sync.Mutex
 	pool        *redis.Pool
 	redisPrefix string
+	leaseOwner  string
+	lockPrefix  string
 	savePeriod  time.Duration
 	wg          sync.WaitGroup
 	modified    bool
 	errHandler  func(k.Error)
 	readOnly    bool
+	metrics     k.Metrics
 }
 
 type Options struct {
@@ -28,12 +39,44 @@ type Options struct {
 	SavePeriod  time.Duration
 	RedisPool   *redis.Pool
 	RedisPrefix string
-	ErrHandler  func(k.Error)
+
+	// If LeaseOwner is set, a shard's checkpoint is only saved while the redis provisioner's lock
+	// on the shard is held by this owner, so that a consumer that lost the lock can't overwrite
+	// the checkpoint of the shard's new owner. The lock is looked for under LockPrefix, whose zero
+	// value is RedisPrefix.
+	LeaseOwner string
+	LockPrefix string
+
+	// ErrHandler is passed errors saving checkpoints. The zero value logs them to Logger.
+	ErrHandler func(k.Error)
+
+	// Logger is what the default ErrHandler logs to. The zero value prints to stdout.
+	Logger k.Logger
+
+	// Metrics is told how long each write of the checkpoints took. The zero value discards it.
+	Metrics k.Metrics
 }
 
+// fencedSaveScript sets the checkpoints in the hash KEYS[1] from the pairs of shard and sequence
+// number in ARGV[3:], skipping the shards whose lock under the prefix ARGV[2] isn't held by
+// ARGV[1]. It returns the shards that were skipped.
+var fencedSaveScript = redis.NewScript(1, `
+local skipped = {}
+for i = 3, #ARGV, 2 do
+	if redis.call("GET", ARGV[2] .. ":lock:" .. ARGV[i]) == ARGV[1] then
+		redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+	else
+		table.insert(skipped, ARGV[i])
+	end
+end
+return skipped
+`)
+
 type Error struct {
 	origin   error
 	severity string
+	// Key/value pairs describing where the error occurred, such as the shard.
+	fields []interface{}
 }
 
 func (e *Error) Severity() string { return e.severity }
@@ -42,80 +85,170 @@ func (e *Error) Origin() error { return e.origin }
 
 func (e *Error) Error() string { return e.origin.Error() }
 
+func (e *Error) Fields() []interface{} { return e.fields }
+
 func New(opt *Options) (*Checkpointer, error) {
 	save := opt.SavePeriod
 	if save == 0 {
 		save = 5 * time.Second
 	}
 
+	if opt.Logger == nil {
+		opt.Logger = textlogger.New(os.Stdout)
+	}
+
 	if opt.ErrHandler == nil {
+		logger := opt.Logger
 		opt.ErrHandler = func(err k.Error) {
-			panic(err)
+			k.LogError(logger, err)
 		}
 	}
 
+	if opt.Metrics == nil {
+		opt.Metrics = emptymetrics.Metrics{}
+	}
+
+	if opt.LockPrefix == "" {
+		opt.LockPrefix = opt.RedisPrefix
+	}
+
+	// The checkpointer saves in the caller's goroutine until RunCheckpointer is started.
+	stopped := make(chan struct{})
+	close(stopped)
+
 	return &Checkpointer{
 		heads:       make(map[string]string),
+		inFlight:    inflight.New(),
 		c:           make(chan k.Record),
+		syncC:       make(chan chan struct{}),
+		stopped:     stopped,
 		mut:         sync.Mutex{},
 		pool:        opt.RedisPool,
 		redisPrefix: opt.RedisPrefix,
+		leaseOwner:  opt.LeaseOwner,
+		lockPrefix:  opt.LockPrefix,
 		savePeriod:  save,
 		modified:    true,
 		errHandler:  opt.ErrHandler,
 		readOnly:    opt.ReadOnly,
+		metrics:     opt.Metrics,
 	}, nil
 }
 
+// Track registers a record as in flight. A shard's head only advances to the highest sequence
+// number below which every tracked record has been marked done, so records that are processed
+// out of order can't cause an earlier record that failed to be skipped on restart.
+func (r *Checkpointer) Track(record k.Record) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.inFlight.Track(k.CheckpointKey(record), record.LeaseEpoch(), record.ExtendedSequenceNumber())
+}
+
+// Revoke stops a shard whose lease was lost from being checkpointed until it is tracked under a
+// later lease, and drops its head so that it doesn't overwrite the checkpoint of its new owner.
+func (r *Checkpointer) Revoke(shardID string, epoch int64) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.inFlight.Revoke(shardID, epoch)
+	delete(r.heads, shardID)
+}
+
 func (r *Checkpointer) DoneC() chan<- k.Record {
 	return r.c
 }
 
+// Sync saves the heads, including those of records that were sent to DoneC before Sync was called.
 func (r *Checkpointer) Sync() {
+	// While RunCheckpointer is running, it saves the heads once it has applied the records it
+	// has been sent.
+	synced := make(chan struct{})
+	select {
+	case r.syncC <- synced:
+		<-synced
+	case <-r.stopped:
+		r.saveHeads()
+	}
+}
+
+func (r *Checkpointer) saveHeads() {
 	if r.readOnly {
 		return
 	}
 
+	// A failed save is reported rather than allowed to stop the checkpointer, since records
+	// would otherwise block forever trying to send to DoneC.
+	defer func() {
+		if val := recover(); val != nil {
+			err := errors.New(fmt.Sprintf("%v", val))
+			r.errHandler(&Error{err, k.EError, []interface{}{"prefix", r.redisPrefix}})
+		}
+	}()
+
 	r.mut.Lock()
 	defer r.mut.Unlock()
 	if len(r.heads) > 0 && r.modified {
 		conn := r.pool.Get()
 		defer conn.Close()
-		if _, err := conn.Do("HMSET", redis.Args{r.redisPrefix + ".sequence"}.AddFlat(r.heads)...); err != nil {
-			r.errHandler(&Error{err, k.EWarn})
+		start := time.Now()
+		err := r.save(conn)
+		r.metrics.CheckpointSaved(time.Since(start), err)
+		if err != nil {
+			r.errHandler(&Error{err, k.EWarn, []interface{}{"prefix", r.redisPrefix}})
 		}
 		r.modified = false
 	}
 }
 
+// save writes the heads. If there is a lease owner, the heads of shards whose lock it no longer
+// holds are dropped rather than written.
+func (r *Checkpointer) save(conn redis.Conn) error {
+	key := r.redisPrefix + ".sequence"
+	if len(r.leaseOwner) == 0 {
+		_, err := conn.Do("HMSET", redis.Args{key}.AddFlat(r.heads)...)
+		return err
+	}
+
+	skipped, err := redis.Strings(fencedSaveScript.Do(conn, redis.Args{key, r.leaseOwner, r.lockPrefix}.AddFlat(r.heads)...))
+	if err != nil {
+		return err
+	}
+	for _, shardID := range skipped {
+		r.errHandler(&Error{fmt.Errorf("Checkpoint for %s not saved, its lock is held by another owner", shardID), k.EWarn, []interface{}{"prefix", r.redisPrefix, "shard", shardID}})
+		delete(r.heads, shardID)
+	}
+	return nil
+}
+
 func (r *Checkpointer) RunCheckpointer() {
-	defer func() {
-		if val := recover(); val != nil {
-			err := errors.New(fmt.Sprintf("%v", val))
-			r.errHandler(&Error{err, k.ECrit})
-		}
-	}()
+	defer r.wg.Done()
+	defer close(r.stopped)
 	saveTicker := time.NewTicker(r.savePeriod).C
 loop:
 	for {
 		select {
 		case <-saveTicker:
-			r.Sync()
+			r.saveHeads()
+		case synced := <-r.syncC:
+			r.saveHeads()
+			close(synced)
 		case state, ok := <-r.c:
 			if !ok {
 				break loop
 			}
 			r.mut.Lock()
-			r.heads[state.ShardId()] = state.SequenceNumber()
-			r.modified = true
+			key := k.CheckpointKey(state)
+			if head, ok := r.inFlight.Done(key, state.LeaseEpoch(), state.ExtendedSequenceNumber()); ok {
+				r.heads[key] = head
+				r.modified = true
+			}
 			r.mut.Unlock()
 		}
 	}
-	r.Sync()
-	r.wg.Done()
+	r.saveHeads()
 }
 
-func (r *Checkpointer) Begin() error {
+func (r *Checkpointer) Begin(ctx context.Context) error {
+	r.stopped = make(chan struct{})
 	r.wg.Add(1)
 	go r.RunCheckpointer()
 	return nil
@@ -126,17 +259,19 @@ func (r *Checkpointer) End() {
 	r.wg.Wait()
 }
 
-func (r *Checkpointer) GetStartSequence(shardID string) string {
+// GetStartSequence returns the shard's checkpoint, or "" if it has none. An error is returned if
+// the checkpoint couldn't be read.
+func (r *Checkpointer) GetStartSequence(ctx context.Context, shardID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	var seq string
-	res, err := conn.Do("HGET", r.redisPrefix+".sequence", shardID)
-	seq, err = redis.String(res, err)
-
-	if err == nil {
-		return seq
-	} else {
-		return ""
+	seq, err := redis.String(conn.Do("HGET", r.redisPrefix+".sequence", shardID))
+	if err == redis.ErrNil {
+		return "", nil
 	}
+	return seq, err
 }
@@ -1,6 +1,7 @@
 package redischeckpointer
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -55,7 +56,7 @@ func TestRedisGoodLogin(t *testing.T) {
 
 func TestCheckpointerBeginEnd(t *testing.T) {
 	r := makeCheckpointerWithSamples()
-	err := r.Begin()
+	err := r.Begin(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -68,30 +69,34 @@ func TestCheckpointerBeginEnd(t *testing.T) {
 
 func TestCheckpointerGetStartSequence(t *testing.T) {
 	r := makeCheckpointerWithSamples()
-	_ = r.Begin()
+	_ = r.Begin(context.Background())
 	r.End()
 	shard1 := "shard1"
-	seq := r.GetStartSequence(shard1)
-	if seq != "1000" {
+	seq, err := r.GetStartSequence(context.Background(), shard1)
+	if err != nil || seq != "1000" {
 		t.Error("Expected nonempty sequence number")
 	}
+	seq, err = r.GetStartSequence(context.Background(), "shard3")
+	if err != nil || seq != "" {
+		t.Error("Expected empty sequence number for a shard that has none")
+	}
 }
 
 func TestCheckpointerSync(t *testing.T) {
 	r := makeCheckpointerWithSamples()
-	r.Begin()
+	r.Begin(context.Background())
 	r.DoneC() <- &FakeRecord{shardId: "shard2", sequenceNumber: "2001"}
 	r.Sync()
 	r.End()
 	r, _ = makeCheckpointer()
-	r.Begin()
+	r.Begin(context.Background())
 	r.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: "1002"}
 	r.Sync()
 	r.End()
 	if r.heads["shard1"] != "1002" {
 		t.Error("Expected sequence number to be written")
 	}
-	if r.GetStartSequence("shard2") != "2001" {
+	if seq, _ := r.GetStartSequence(context.Background(), "shard2"); seq != "2001" {
 		t.Error("Expected sequence number to be written by first checkpointer")
 	}
 	if len(r.heads) != 1 {
@@ -99,6 +104,62 @@ func TestCheckpointerSync(t *testing.T) {
 	}
 }
 
+func TestCheckpointerSyncLeaseOwner(t *testing.T) {
+	r := makeCheckpointerWithSamples()
+	r.leaseOwner = "me"
+	conn := r.pool.Get()
+	defer conn.Close()
+	conn.Do("SET", prefix+":lock:shard1", "me")
+	conn.Do("SET", prefix+":lock:shard2", "other")
+	defer conn.Do("DEL", prefix+":lock:shard1", prefix+":lock:shard2")
+
+	r.Begin(context.Background())
+	r.DoneC() <- &FakeRecord{shardId: "shard1", sequenceNumber: "1001"}
+	r.DoneC() <- &FakeRecord{shardId: "shard2", sequenceNumber: "2001"}
+	r.End()
+	if seq, _ := r.GetStartSequence(context.Background(), "shard1"); seq != "1001" {
+		t.Error("Expected sequence number of a shard whose lock is held to be written")
+	}
+	if seq, _ := r.GetStartSequence(context.Background(), "shard2"); seq != "2000" {
+		t.Error("Expected sequence number of a shard whose lock is held by another owner not to be written")
+	}
+}
+
+func TestCheckpointerOutOfOrder(t *testing.T) {
+	r, _ := makeCheckpointer()
+	r.readOnly = true
+	r.Begin(context.Background())
+	records := []*FakeRecord{
+		{shardId: "shard1", sequenceNumber: "7"},
+		{shardId: "shard1", sequenceNumber: "9"},
+		{shardId: "shard1", sequenceNumber: "10"},
+	}
+	for _, record := range records {
+		r.Track(record)
+	}
+
+	r.DoneC() <- records[2]
+	r.DoneC() <- records[1]
+	r.End()
+	if _, ok := r.heads["shard1"]; ok {
+		t.Error("Expected head not to advance past a record that isn't done")
+	}
+
+	r, _ = makeCheckpointer()
+	r.readOnly = true
+	r.Begin(context.Background())
+	for _, record := range records {
+		r.Track(record)
+	}
+	r.DoneC() <- records[2]
+	r.DoneC() <- records[0]
+	r.DoneC() <- records[1]
+	r.End()
+	if r.heads["shard1"] != "10" {
+		t.Error("Expected head to advance once all records are done")
+	}
+}
+
 type FakeRecord struct {
 	sequenceNumber string
 	shardId        string
@@ -116,13 +177,41 @@ func (r *FakeRecord) SequenceNumber() string {
 	return r.sequenceNumber
 }
 
+func (r *FakeRecord) SubSequenceNumber() int64 {
+	return 0
+}
+
+func (r *FakeRecord) ExtendedSequenceNumber() string {
+	return r.sequenceNumber
+}
+
 func (r *FakeRecord) ShardId() string {
 	return r.shardId
 }
 
+func (r *FakeRecord) StreamName() string {
+	return ""
+}
+
 func (r *FakeRecord) MillisBehindLatest() int64 {
 	return -1
 }
 
+func (r *FakeRecord) ApproximateArrivalTimestamp() time.Time {
+	return time.Time{}
+}
+
+func (r *FakeRecord) EncryptionType() string {
+	return ""
+}
+
+func (r *FakeRecord) ExplicitHashKey() string {
+	return ""
+}
+
+func (r *FakeRecord) LeaseEpoch() int64 {
+	return 0
+}
+
 func (r *FakeRecord) Done() {
 }
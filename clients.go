@@ -0,0 +1,136 @@
+package kinesumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// DefaultRoleSessionName is the session name that roles are assumed with if none is set.
+const DefaultRoleSessionName = "kinesumer"
+
+// Role is an IAM role that is assumed to read streams, such as those in another account.
+type Role struct {
+	ARN string
+
+	// ExternalID is given when assuming the role if it is set, for roles whose trust policy
+	// requires one.
+	ExternalID string
+
+	// SessionName is the name of the role session. If it is empty, DefaultRoleSessionName is used.
+	SessionName string
+
+	// Duration is how long the role's credentials last before they're refreshed. If it is 0, the
+	// SDK's default of 15 minutes is used.
+	Duration time.Duration
+}
+
+// Clients makes the Kinesis client for each stream that is read or put on, in the stream's region
+// and with the credentials of the role configured for it. Clients for the same region and role are
+// shared.
+type Clients struct {
+	// Config is what the clients are made with. If it is nil, the default config is loaded from
+	// the environment and shared config files.
+	Config *aws.Config
+
+	// Roles are the roles to assume, by stream name or ARN, or by region. A stream's own role is
+	// assumed over its region's. Streams without either are read with the Config's credentials.
+	Roles map[string]Role
+
+	mut     sync.Mutex
+	clients map[clientKey]*kinesis.Client
+}
+
+type clientKey struct {
+	region string
+	role   Role
+}
+
+// Kinesis returns the client that stream, a name or ARN, is read with. A stream addressed by ARN
+// is read in its ARN's region; one addressed by name is read in the Config's.
+func (c *Clients) Kinesis(stream string) (k.Kinesis, error) {
+	client, err := c.client(stream)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// KinesisPutter returns the client that records are put on stream with, which is the same one
+// that Kinesis returns for it.
+func (c *Clients) KinesisPutter(stream string) (k.KinesisPutter, error) {
+	client, err := c.client(stream)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Clients) client(stream string) (*kinesis.Client, error) {
+	var region string
+	if IsStreamARN(stream) {
+		arn, err := ParseStreamARN(stream)
+		if err != nil {
+			return nil, NewError(ECrit, "Invalid stream ARN", err).With("stream", stream)
+		}
+		region = arn.Region
+	}
+
+	role, ok := c.Roles[stream]
+	if !ok {
+		role, ok = c.Roles[streamName(stream)]
+	}
+	if !ok && region != "" {
+		role = c.Roles[region]
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.Config == nil {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, NewError(ECrit, "Failed to load AWS config", err)
+		}
+		c.Config = &cfg
+	}
+	if c.clients == nil {
+		c.clients = make(map[clientKey]*kinesis.Client)
+	}
+
+	key := clientKey{region: region, role: role}
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	cfg := c.Config.Copy()
+	if region != "" {
+		cfg.Region = region
+	}
+	if role.ARN != "" {
+		// Roles are assumed with the Config's own credentials, in its region, rather than the
+		// stream's.
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(*c.Config), role.ARN,
+			func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = role.SessionName
+				if o.RoleSessionName == "" {
+					o.RoleSessionName = DefaultRoleSessionName
+				}
+				if role.ExternalID != "" {
+					o.ExternalID = aws.String(role.ExternalID)
+				}
+				o.Duration = role.Duration
+			})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	client := kinesis.NewFromConfig(cfg)
+	c.clients[key] = client
+	return client, nil
+}
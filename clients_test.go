@@ -0,0 +1,57 @@
+package kinesumer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientsKinesis(t *testing.T) {
+	c := &Clients{
+		Config: &aws.Config{
+			Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+			Region:      "us-east-1",
+		},
+		Roles: map[string]Role{
+			"us-west-2": {ARN: "arn:aws:iam::123456789012:role/reader"},
+			"arn:aws:kinesis:us-west-2:123456789012:stream/audit": {ARN: "arn:aws:iam::123456789012:role/auditor"},
+		},
+	}
+
+	local, err := c.Kinesis("events")
+	assert.Nil(t, err)
+	assert.Equal(t, "us-east-1", local.(*kinesis.Client).Options().Region)
+	v, err := local.(*kinesis.Client).Options().Credentials.Retrieve(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "id", v.AccessKeyID)
+
+	events, err := c.Kinesis("arn:aws:kinesis:us-west-2:123456789012:stream/events")
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", events.(*kinesis.Client).Options().Region)
+	assert.NotEqual(t, local, events)
+	creds := events.(*kinesis.Client).Options().Credentials.(*aws.CredentialsCache)
+	assert.True(t, creds.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}))
+
+	// Streams in the same region with the same role share a client, unless they have their own.
+	clicks, err := c.Kinesis("arn:aws:kinesis:us-west-2:123456789012:stream/clicks")
+	assert.Nil(t, err)
+	assert.True(t, events == clicks)
+	audit, err := c.Kinesis("arn:aws:kinesis:us-west-2:123456789012:stream/audit")
+	assert.Nil(t, err)
+	assert.True(t, events != audit)
+
+	_, err = c.Kinesis("arn:aws:kinesis:us-west-2:123456789012:audit")
+	assert.Error(t, err)
+
+	// Records are put with the same client that the stream is read with.
+	putter, err := c.KinesisPutter("arn:aws:kinesis:us-west-2:123456789012:stream/events")
+	assert.Nil(t, err)
+	assert.True(t, putter.(*kinesis.Client) == events.(*kinesis.Client))
+	_, err = c.KinesisPutter("arn:aws:kinesis:us-west-2:123456789012:audit")
+	assert.Error(t, err)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/remind101/kinesumer"
+)
+
+var cmdPut = cli.Command{
+	Name:    "put",
+	Aliases: []string{"p"},
+	Usage:   "Puts each line of standard in on a Kinesis stream",
+	Action:  runPut,
+	Flags:   append(flagsStream, flagsLog...),
+}
+
+func runPut(ctx *cli.Context) {
+	stream := getStream(ctx)
+	client, err := new(kinesumer.Clients).KinesisPutter(stream)
+	if err != nil {
+		panic(err)
+	}
+	p, err := kinesumer.NewProducer(client, stream, nil)
+	if err != nil {
+		panic(err)
+	}
+	p.Options.ErrHandler = kinesumer.LogErrHandler(getLogger(ctx))
+
+	p.Begin()
+	defer p.End()
+
+	w := kinesumer.NewWriter(p)
+	if _, err := io.Copy(w, os.Stdin); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"sort"
@@ -65,7 +66,7 @@ func runShards(ctx *cli.Context) {
 		panic(err)
 	}
 
-	shards, err := k.GetShards()
+	shards, err := k.GetShards(context.Background())
 	if err != nil {
 		panic(err)
 	}
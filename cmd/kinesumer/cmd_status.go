@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"time"
 
 	"github.com/codegangsta/cli"
@@ -53,7 +54,7 @@ func runStatus(ctx *cli.Context) {
 			RedisPrefix: prefix,
 		})
 
-		err = cp.Begin()
+		err = cp.Begin(context.Background())
 		if err != nil {
 			panic(err)
 		}
@@ -70,7 +71,7 @@ func runStatus(ctx *cli.Context) {
 		header.AddCellWithf("Sequence Number")
 	}
 
-	shards, err := k.GetShards()
+	shards, err := k.GetShards(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -91,9 +92,13 @@ func runStatus(ctx *cli.Context) {
 				cell.Color = color.New(color.FgRed)
 			}
 			cell.Printf("%s", lock)
-			seqStart := StrShorten(cp.GetStartSequence(*shard.ShardId), 8, 8)
+			seqStart, err := cp.GetStartSequence(context.Background(), *shard.ShardId)
+			seqStart = StrShorten(seqStart, 8, 8)
 			cell = row.AddCell()
-			if len(seqStart) == 0 {
+			if err != nil {
+				seqStart = err.Error()
+				cell.Color = color.New(color.FgRed)
+			} else if len(seqStart) == 0 {
 				seqStart = "???"
 				cell.Color = color.New(color.FgRed)
 			}
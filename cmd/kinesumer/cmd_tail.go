@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/codegangsta/cli"
-	"github.com/fatih/color"
 	"github.com/remind101/kinesumer"
 	"github.com/remind101/kinesumer/checkpointers/redis"
 	"github.com/remind101/kinesumer/redispool"
@@ -27,22 +26,10 @@ var cmdTail = cli.Command{
 				Name:  "duration, d",
 				Usage: "Duration to go back and stream logs from",
 			},
-		}, flagsRedis...,
+		}, append(flagsRedis, flagsLog...)...,
 	),
 }
 
-func errHandler(err kinesumer.IError) {
-	switch err.Severity() {
-	case kinesumer.ECrit:
-		fallthrough
-	case kinesumer.EError:
-		color.Red("%s:%s\n", err.Severity(), err.Error())
-		panic(err)
-	default:
-		color.Yellow("%s:%s\n", err.Severity(), err.Error())
-	}
-}
-
 func runTail(ctx *cli.Context) {
 	var duration time.Duration
 	var err error
@@ -60,7 +47,9 @@ func runTail(ctx *cli.Context) {
 		panic(err)
 	}
 
-	k.Options.ErrHandler = kinesumer.ErrHandler(errHandler)
+	logger := getLogger(ctx)
+	k.Options.Logger = logger
+	k.Options.ErrHandler = kinesumer.LogErrHandler(logger)
 
 	if redisURL := ctx.String(fRedisURL); len(redisURL) > 0 {
 		pool, err := redispool.NewRedisPool(redisURL)
@@ -72,6 +61,7 @@ func runTail(ctx *cli.Context) {
 			ReadOnly:    true,
 			RedisPool:   pool,
 			RedisPrefix: ctx.String(fRedisPrefix),
+			Logger:      logger,
 		})
 		if err != nil {
 			panic(err)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/remind101/kinesumer"
+	"github.com/remind101/kinesumer/loggers/slog"
+)
+
+var (
+	fLogFormat = "log.format"
+	fLogLevel  = "log.level"
+)
+
+var flagsLog = []cli.Flag{
+	cli.StringFlag{
+		Name:   fLogFormat,
+		Value:  "text",
+		Usage:  "The format of log messages written to standard error, text or json",
+		EnvVar: "LOG_FORMAT",
+	},
+	cli.StringFlag{
+		Name:   fLogLevel,
+		Value:  "info",
+		Usage:  "The lowest level of messages to log: debug, info, warn or error",
+		EnvVar: "LOG_LEVEL",
+	},
+}
+
+// getLogger returns a logger that writes to standard error, so that it doesn't mix with records
+// piped to standard out.
+func getLogger(ctx *cli.Context) kinesumer.ILogger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(ctx.String(fLogLevel))); err != nil {
+		panic(err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if ctx.String(fLogFormat) == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	return sloglogger.New(slog.New(handler))
+}
@@ -0,0 +1,53 @@
+// Package filedeadletter parks records that failed to be processed in a local file.
+package filedeadletter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/remind101/kinesumer/deadletters/letter"
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// DeadLetter appends each record it parks to a file as a line of JSON, a letter.Letter.
+type DeadLetter struct {
+	mut  sync.Mutex
+	file *os.File
+}
+
+// New opens the file at path for appending, creating it if it doesn't exist.
+func New(path string) (*DeadLetter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{file: file}, nil
+}
+
+// Park writes the record to the file and syncs it, so that it isn't lost if the process dies
+// once the record's shard has moved past it.
+func (d *DeadLetter) Park(ctx context.Context, record k.Record, reason error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(letter.New(record, reason))
+	if err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if _, err := d.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+func (d *DeadLetter) Close() error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return d.file.Close()
+}
@@ -0,0 +1,62 @@
+package filedeadletter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/remind101/kinesumer/deadletters/letter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterPark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	d, err := New(path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, d.Park(context.Background(), &fakeRecord{shardID: "shard0", sequenceNumber: "123"}, errors.New("first")))
+	assert.Nil(t, d.Park(context.Background(), &fakeRecord{shardID: "shard1", sequenceNumber: "456"}, errors.New("second")))
+	assert.Nil(t, d.Close())
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var letters []letter.Letter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l letter.Letter
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &l))
+		letters = append(letters, l)
+	}
+	if assert.Len(t, letters, 2) {
+		assert.Equal(t, "shard0", letters[0].ShardID)
+		assert.Equal(t, "first", letters[0].Reason)
+		assert.Equal(t, "456", letters[1].SequenceNumber)
+		assert.Equal(t, "second", letters[1].Reason)
+	}
+}
+
+type fakeRecord struct {
+	shardID        string
+	sequenceNumber string
+}
+
+func (r *fakeRecord) Data() []byte                           { return nil }
+func (r *fakeRecord) PartitionKey() string                   { return "" }
+func (r *fakeRecord) SequenceNumber() string                 { return r.sequenceNumber }
+func (r *fakeRecord) SubSequenceNumber() int64               { return 0 }
+func (r *fakeRecord) ExtendedSequenceNumber() string         { return r.sequenceNumber }
+func (r *fakeRecord) ShardId() string                        { return r.shardID }
+func (r *fakeRecord) StreamName() string                     { return "" }
+func (r *fakeRecord) MillisBehindLatest() int64              { return 0 }
+func (r *fakeRecord) ApproximateArrivalTimestamp() time.Time { return time.Time{} }
+func (r *fakeRecord) EncryptionType() string                 { return "" }
+func (r *fakeRecord) ExplicitHashKey() string                { return "" }
+func (r *fakeRecord) LeaseEpoch() int64                      { return 0 }
+func (r *fakeRecord) Done()                                  {}
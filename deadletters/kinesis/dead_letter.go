@@ -0,0 +1,97 @@
+// Package kinesisdeadletter parks records that failed to be processed on another Kinesis stream.
+package kinesisdeadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/remind101/kinesumer/deadletters/letter"
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// maxRecordSize is the most data and partition key that a Kinesis record can hold.
+const maxRecordSize = 1 << 20
+
+// DeadLetter puts each record it parks on a stream as a JSON letter.Letter, under the record's
+// own partition key so that letters from the same key stay in order.
+type DeadLetter struct {
+	kinesis k.KinesisPutter
+	stream  string
+}
+
+type Options struct {
+	Kinesis k.KinesisPutter
+	// Stream is the name or ARN of the stream that letters are put on.
+	Stream string
+}
+
+func New(opt *Options) (*DeadLetter, error) {
+	if opt.Kinesis == nil {
+		return nil, errors.New("Kinesis client must not be nil")
+	}
+	if len(opt.Stream) == 0 {
+		return nil, errors.New("Stream name can't be empty")
+	}
+
+	return &DeadLetter{
+		kinesis: opt.Kinesis,
+		stream:  opt.Stream,
+	}, nil
+}
+
+func (d *DeadLetter) Park(ctx context.Context, record k.Record, reason error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key := record.PartitionKey()
+	if len(key) == 0 {
+		key = record.ShardId()
+	}
+	data, err := marshal(letter.New(record, reason), maxRecordSize-len(key))
+	if err != nil {
+		return err
+	}
+	input := &kinesis.PutRecordInput{
+		Data:         data,
+		PartitionKey: aws.String(key),
+	}
+	if strings.HasPrefix(d.stream, "arn:") {
+		input.StreamARN = aws.String(d.stream)
+	} else {
+		input.StreamName = aws.String(d.stream)
+	}
+	_, err = d.kinesis.PutRecord(ctx, input)
+	return err
+}
+
+// marshal returns the letter as JSON of at most size bytes. The record's data is base64 encoded in
+// the letter, which makes it a third bigger, so a record near the size limit is truncated to fit.
+func marshal(l *letter.Letter, size int) ([]byte, error) {
+	data, err := json.Marshal(l)
+	if err != nil || len(data) <= size {
+		return data, err
+	}
+
+	full := l.Data
+	l.Data, l.Truncated, l.DataSize = nil, true, len(full)
+	data, err = json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	if n := (size - len(data)) / 4 * 3; n > 0 {
+		l.Data = full[:n]
+		if data, err = json.Marshal(l); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > size {
+		return nil, fmt.Errorf("Letter is %d bytes, more than the %d that fit in a record", len(data), size)
+	}
+	return data, nil
+}
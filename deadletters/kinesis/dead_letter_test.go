@@ -0,0 +1,112 @@
+package kinesisdeadletter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/remind101/kinesumer/deadletters/letter"
+	"github.com/remind101/kinesumer/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDeadLetterPark(t *testing.T) {
+	kin := new(mocks.Kinesis)
+	d, err := New(&Options{Kinesis: kin, Stream: "dead-letters"})
+	assert.Nil(t, err)
+
+	var input *kinesis.PutRecordInput
+	kin.On("PutRecord", mock.Anything, mock.Anything).Return(&kinesis.PutRecordOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*kinesis.PutRecordInput)
+	})
+
+	record := &fakeRecord{shardID: "shard0", sequenceNumber: "123", partitionKey: "key", data: []byte("bad")}
+	assert.Nil(t, d.Park(context.Background(), record, errors.New("could not parse")))
+
+	assert.Equal(t, "dead-letters", aws.ToString(input.StreamName))
+	assert.Nil(t, input.StreamARN)
+	assert.Equal(t, "key", aws.ToString(input.PartitionKey))
+	var l letter.Letter
+	assert.Nil(t, json.Unmarshal(input.Data, &l))
+	assert.Equal(t, "shard0", l.ShardID)
+	assert.Equal(t, "123", l.SequenceNumber)
+	assert.Equal(t, []byte("bad"), l.Data)
+	assert.Equal(t, "could not parse", l.Reason)
+}
+
+func TestDeadLetterParkOversized(t *testing.T) {
+	kin := new(mocks.Kinesis)
+	d, err := New(&Options{Kinesis: kin, Stream: "dead-letters"})
+	assert.Nil(t, err)
+
+	var input *kinesis.PutRecordInput
+	kin.On("PutRecord", mock.Anything, mock.Anything).Return(&kinesis.PutRecordOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*kinesis.PutRecordInput)
+	})
+
+	// A record of the most data Kinesis allows is a third bigger once it's base64 encoded.
+	data := bytes.Repeat([]byte("x"), maxRecordSize-3)
+	record := &fakeRecord{shardID: "shard0", sequenceNumber: "123", partitionKey: "key", data: data}
+	assert.Nil(t, d.Park(context.Background(), record, errors.New("too big")))
+
+	assert.True(t, len(input.Data)+len(aws.ToString(input.PartitionKey)) <= maxRecordSize)
+	var l letter.Letter
+	assert.Nil(t, json.Unmarshal(input.Data, &l))
+	assert.True(t, l.Truncated)
+	assert.Equal(t, len(data), l.DataSize)
+	assert.True(t, len(l.Data) > 0)
+	assert.Equal(t, data[:len(l.Data)], l.Data)
+	assert.Equal(t, "too big", l.Reason)
+}
+
+func TestDeadLetterParkStreamARN(t *testing.T) {
+	kin := new(mocks.Kinesis)
+	arn := "arn:aws:kinesis:us-east-1:123456789012:stream/dead-letters"
+	d, err := New(&Options{Kinesis: kin, Stream: arn})
+	assert.Nil(t, err)
+
+	var input *kinesis.PutRecordInput
+	kin.On("PutRecord", mock.Anything, mock.Anything).Return(&kinesis.PutRecordOutput{}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*kinesis.PutRecordInput)
+	})
+
+	record := &fakeRecord{shardID: "shard0", sequenceNumber: "123", partitionKey: "key", data: []byte("bad")}
+	assert.Nil(t, d.Park(context.Background(), record, errors.New("could not parse")))
+
+	assert.Equal(t, arn, aws.ToString(input.StreamARN))
+	assert.Nil(t, input.StreamName)
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := New(&Options{Stream: "dead-letters"})
+	assert.Error(t, err)
+	_, err = New(&Options{Kinesis: new(mocks.Kinesis)})
+	assert.Error(t, err)
+}
+
+type fakeRecord struct {
+	shardID        string
+	sequenceNumber string
+	partitionKey   string
+	data           []byte
+}
+
+func (r *fakeRecord) Data() []byte                           { return r.data }
+func (r *fakeRecord) PartitionKey() string                   { return r.partitionKey }
+func (r *fakeRecord) SequenceNumber() string                 { return r.sequenceNumber }
+func (r *fakeRecord) SubSequenceNumber() int64               { return 0 }
+func (r *fakeRecord) ExtendedSequenceNumber() string         { return r.sequenceNumber }
+func (r *fakeRecord) ShardId() string                        { return r.shardID }
+func (r *fakeRecord) StreamName() string                     { return "" }
+func (r *fakeRecord) MillisBehindLatest() int64              { return 0 }
+func (r *fakeRecord) ApproximateArrivalTimestamp() time.Time { return time.Time{} }
+func (r *fakeRecord) EncryptionType() string                 { return "" }
+func (r *fakeRecord) ExplicitHashKey() string                { return "" }
+func (r *fakeRecord) LeaseEpoch() int64                      { return 0 }
+func (r *fakeRecord) Done()                                  {}
@@ -0,0 +1,43 @@
+// Package letter defines the JSON document that the dead letter sinks park a record as.
+package letter
+
+import (
+	"time"
+
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// Letter is a record that failed to be processed, and where it was read from. Truncated is set if
+// Data was cut short to fit in the sink, in which case DataSize is the size of the record's data.
+type Letter struct {
+	StreamName        string    `json:"streamName,omitempty"`
+	ShardID           string    `json:"shardId"`
+	SequenceNumber    string    `json:"sequenceNumber"`
+	SubSequenceNumber int64     `json:"subSequenceNumber,omitempty"`
+	PartitionKey      string    `json:"partitionKey"`
+	ExplicitHashKey   string    `json:"explicitHashKey,omitempty"`
+	Data              []byte    `json:"data"`
+	Reason            string    `json:"reason"`
+	ArrivedAt         time.Time `json:"approximateArrivalTimestamp"`
+	ParkedAt          time.Time `json:"parkedAt"`
+	Truncated         bool      `json:"truncated,omitempty"`
+	DataSize          int       `json:"dataSize,omitempty"`
+}
+
+func New(record k.Record, reason error) *Letter {
+	l := &Letter{
+		StreamName:        record.StreamName(),
+		ShardID:           record.ShardId(),
+		SequenceNumber:    record.SequenceNumber(),
+		SubSequenceNumber: record.SubSequenceNumber(),
+		PartitionKey:      record.PartitionKey(),
+		ExplicitHashKey:   record.ExplicitHashKey(),
+		Data:              record.Data(),
+		ArrivedAt:         record.ApproximateArrivalTimestamp(),
+		ParkedAt:          time.Now().UTC(),
+	}
+	if reason != nil {
+		l.Reason = reason.Error()
+	}
+	return l
+}
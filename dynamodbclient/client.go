@@ -0,0 +1,249 @@
+// Package dynamodbclient is the small set of DynamoDB operations used by the DynamoDB checkpointer
+// and provisioner, with simple types so that they are easy to mock. Client implements it with the
+// SDK's DynamoDB client.
+package dynamodbclient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// tablePollInterval is how often EnsureTable checks whether a new table has become active.
+const tablePollInterval = time.Second
+
+// API is the set of DynamoDB operations used by kinesumer, so that they can be mocked.
+type API interface {
+	CreateTable(ctx context.Context, input *CreateTableInput) (*CreateTableOutput, error)
+	DescribeTable(ctx context.Context, input *DescribeTableInput) (*DescribeTableOutput, error)
+	GetItem(ctx context.Context, input *GetItemInput) (*GetItemOutput, error)
+	PutItem(ctx context.Context, input *PutItemInput) (*PutItemOutput, error)
+	UpdateItem(ctx context.Context, input *UpdateItemInput) (*UpdateItemOutput, error)
+	Scan(ctx context.Context, input *ScanInput) (*ScanOutput, error)
+}
+
+// Client implements API with the SDK's DynamoDB client.
+type Client struct {
+	DynamoDB *dynamodb.Client
+}
+
+// New creates a client with cfg, in the same way as the SDK's service clients.
+func New(cfg aws.Config, optFns ...func(*dynamodb.Options)) *Client {
+	return &Client{DynamoDB: dynamodb.NewFromConfig(cfg, optFns...)}
+}
+
+func (c *Client) CreateTable(ctx context.Context, input *CreateTableInput) (*CreateTableOutput, error) {
+	in := &dynamodb.CreateTableInput{
+		BillingMode: types.BillingMode(aws.ToString(input.BillingMode)),
+		TableName:   input.TableName,
+	}
+	for _, def := range input.AttributeDefinitions {
+		in.AttributeDefinitions = append(in.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: def.AttributeName,
+			AttributeType: types.ScalarAttributeType(aws.ToString(def.AttributeType)),
+		})
+	}
+	for _, key := range input.KeySchema {
+		in.KeySchema = append(in.KeySchema, types.KeySchemaElement{
+			AttributeName: key.AttributeName,
+			KeyType:       types.KeyType(aws.ToString(key.KeyType)),
+		})
+	}
+
+	out, err := c.DynamoDB.CreateTable(ctx, in)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &CreateTableOutput{TableDescription: fromTableDescription(out.TableDescription)}, nil
+}
+
+func (c *Client) DescribeTable(ctx context.Context, input *DescribeTableInput) (*DescribeTableOutput, error) {
+	out, err := c.DynamoDB.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: input.TableName})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &DescribeTableOutput{Table: fromTableDescription(out.Table)}, nil
+}
+
+func (c *Client) GetItem(ctx context.Context, input *GetItemInput) (*GetItemOutput, error) {
+	out, err := c.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		ConsistentRead: input.ConsistentRead,
+		Key:            toItem(input.Key),
+		TableName:      input.TableName,
+	})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &GetItemOutput{Item: fromItem(out.Item)}, nil
+}
+
+func (c *Client) PutItem(ctx context.Context, input *PutItemInput) (*PutItemOutput, error) {
+	_, err := c.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		ConditionExpression:       input.ConditionExpression,
+		ExpressionAttributeNames:  toNames(input.ExpressionAttributeNames),
+		ExpressionAttributeValues: toItem(input.ExpressionAttributeValues),
+		Item:                      toItem(input.Item),
+		TableName:                 input.TableName,
+	})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &PutItemOutput{}, nil
+}
+
+func (c *Client) UpdateItem(ctx context.Context, input *UpdateItemInput) (*UpdateItemOutput, error) {
+	out, err := c.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ConditionExpression:       input.ConditionExpression,
+		ExpressionAttributeNames:  toNames(input.ExpressionAttributeNames),
+		ExpressionAttributeValues: toItem(input.ExpressionAttributeValues),
+		Key:                       toItem(input.Key),
+		ReturnValues:              types.ReturnValue(aws.ToString(input.ReturnValues)),
+		TableName:                 input.TableName,
+		UpdateExpression:          input.UpdateExpression,
+	})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &UpdateItemOutput{Attributes: fromItem(out.Attributes)}, nil
+}
+
+func (c *Client) Scan(ctx context.Context, input *ScanInput) (*ScanOutput, error) {
+	out, err := c.DynamoDB.Scan(ctx, &dynamodb.ScanInput{
+		ConsistentRead:    input.ConsistentRead,
+		ExclusiveStartKey: toItem(input.ExclusiveStartKey),
+		TableName:         input.TableName,
+	})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	items := make([]map[string]*AttributeValue, 0, len(out.Items))
+	for _, item := range out.Items {
+		items = append(items, fromItem(item))
+	}
+	return &ScanOutput{Items: items, LastEvaluatedKey: fromItem(out.LastEvaluatedKey)}, nil
+}
+
+// contextError returns ctx's error rather than err if ctx is done, as callers check for it.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func fromTableDescription(desc *types.TableDescription) *TableDescription {
+	if desc == nil {
+		return nil
+	}
+	return &TableDescription{
+		TableName:   desc.TableName,
+		TableStatus: aws.String(string(desc.TableStatus)),
+	}
+}
+
+func toNames(names map[string]*string) map[string]string {
+	if names == nil {
+		return nil
+	}
+	out := make(map[string]string, len(names))
+	for k, v := range names {
+		out[k] = aws.ToString(v)
+	}
+	return out
+}
+
+func toItem(item map[string]*AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	out := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		switch {
+		case v == nil:
+		case v.S != nil:
+			out[k] = &types.AttributeValueMemberS{Value: *v.S}
+		case v.N != nil:
+			out[k] = &types.AttributeValueMemberN{Value: *v.N}
+		case v.SS != nil:
+			out[k] = &types.AttributeValueMemberSS{Value: aws.ToStringSlice(v.SS)}
+		}
+	}
+	return out
+}
+
+// fromItem converts an item, leaving out attributes of types kinesumer doesn't use.
+func fromItem(item map[string]types.AttributeValue) map[string]*AttributeValue {
+	if item == nil {
+		return nil
+	}
+	out := make(map[string]*AttributeValue, len(item))
+	for k, v := range item {
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			out[k] = S(v.Value)
+		case *types.AttributeValueMemberN:
+			out[k] = &AttributeValue{N: aws.String(v.Value)}
+		case *types.AttributeValueMemberSS:
+			out[k] = &AttributeValue{SS: aws.StringSlice(v.Value)}
+		}
+	}
+	return out
+}
+
+// IsConditionalCheckFailed returns whether err is from a write whose condition expression wasn't
+// met.
+func IsConditionalCheckFailed(err error) bool {
+	return isCode(err, "ConditionalCheckFailedException")
+}
+
+// EnsureTable creates an on-demand table keyed by the string attribute hashKey if it doesn't
+// exist, and waits for the table to become active.
+func EnsureTable(ctx context.Context, db API, table, hashKey string) error {
+	for {
+		desc, err := db.DescribeTable(ctx, &DescribeTableInput{TableName: aws.String(table)})
+		if isCode(err, "ResourceNotFoundException") {
+			err = createTable(ctx, db, table, hashKey)
+		} else if err == nil && desc.Table != nil && aws.ToString(desc.Table.TableStatus) == "ACTIVE" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(tablePollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func createTable(ctx context.Context, db API, table, hashKey string) error {
+	_, err := db.CreateTable(ctx, &CreateTableInput{
+		AttributeDefinitions: []*AttributeDefinition{{
+			AttributeName: aws.String(hashKey),
+			AttributeType: aws.String("S"),
+		}},
+		BillingMode: aws.String("PAY_PER_REQUEST"),
+		KeySchema: []*KeySchemaElement{{
+			AttributeName: aws.String(hashKey),
+			KeyType:       aws.String("HASH"),
+		}},
+		TableName: aws.String(table),
+	})
+	if isCode(err, "ResourceInUseException") {
+		// Another consumer is creating the table.
+		return nil
+	}
+	return err
+}
+
+func isCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
@@ -0,0 +1,107 @@
+package dynamodbclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRequest struct {
+	target string
+	body   map[string]interface{}
+}
+
+// newTestClient returns a client for a server that records requests and answers each of them
+// with the next of responses.
+func newTestClient(t *testing.T, responses ...string) (*Client, *[]testRequest, func()) {
+	requests := []testRequest{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := testRequest{target: r.Header.Get("X-Amz-Target")}
+		json.NewDecoder(r.Body).Decode(&req.body)
+		requests = append(requests, req)
+
+		if len(responses) == 0 {
+			t.Errorf("unexpected request %s", req.target)
+			return
+		}
+		if responses[0][0] == '!' {
+			w.WriteHeader(400)
+			responses[0] = responses[0][1:]
+		}
+		w.Write([]byte(responses[0]))
+		responses = responses[1:]
+	}))
+
+	c := New(aws.Config{
+		Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		Region:           "us-east-1",
+		RetryMaxAttempts: 1,
+	}, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(ts.URL)
+	})
+	return c, &requests, ts.Close
+}
+
+func TestClientUpdateItem(t *testing.T) {
+	c, requests, close := newTestClient(t, `{"Attributes":{"leaseCounter":{"N":"2"}}}`)
+	defer close()
+
+	out, err := c.UpdateItem(context.Background(), &UpdateItemInput{
+		ConditionExpression:       aws.String("leaseOwner = :owner"),
+		ExpressionAttributeValues: map[string]*AttributeValue{":owner": S("worker1"), ":one": N(1)},
+		Key:                       map[string]*AttributeValue{"leaseKey": S("shard1")},
+		ReturnValues:              aws.String("ALL_NEW"),
+		TableName:                 aws.String("leases"),
+		UpdateExpression:          aws.String("ADD leaseCounter :one"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), Int64Value(out.Attributes["leaseCounter"]))
+
+	assert.Equal(t, "DynamoDB_20120810.UpdateItem", (*requests)[0].target)
+	assert.Equal(t, map[string]interface{}{
+		"ConditionExpression": "leaseOwner = :owner",
+		"ExpressionAttributeValues": map[string]interface{}{
+			":owner": map[string]interface{}{"S": "worker1"},
+			":one":   map[string]interface{}{"N": "1"},
+		},
+		"Key":              map[string]interface{}{"leaseKey": map[string]interface{}{"S": "shard1"}},
+		"ReturnValues":     "ALL_NEW",
+		"TableName":        "leases",
+		"UpdateExpression": "ADD leaseCounter :one",
+	}, (*requests)[0].body)
+}
+
+func TestClientConditionalCheckFailed(t *testing.T) {
+	c, _, close := newTestClient(t, `!{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`)
+	defer close()
+
+	_, err := c.PutItem(context.Background(), &PutItemInput{
+		ConditionExpression: aws.String("attribute_not_exists(leaseKey)"),
+		Item:                map[string]*AttributeValue{"leaseKey": S("shard1")},
+		TableName:           aws.String("leases"),
+	})
+	assert.True(t, IsConditionalCheckFailed(err))
+}
+
+func TestEnsureTable(t *testing.T) {
+	c, requests, close := newTestClient(t,
+		`!{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`,
+		`{"TableDescription":{"TableName":"leases","TableStatus":"CREATING"}}`,
+		`{"Table":{"TableName":"leases","TableStatus":"ACTIVE"}}`,
+	)
+	defer close()
+
+	assert.NoError(t, EnsureTable(context.Background(), c, "leases", "leaseKey"))
+	assert.Equal(t, 3, len(*requests))
+	assert.Equal(t, "DynamoDB_20120810.CreateTable", (*requests)[1].target)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"AttributeName": "leaseKey", "KeyType": "HASH"},
+	}, (*requests)[1].body["KeySchema"])
+}
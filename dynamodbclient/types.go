@@ -0,0 +1,122 @@
+package dynamodbclient
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// The types below mirror the SDK's DynamoDB types, limited to the fields kinesumer uses, with
+// attribute values as plain structs rather than the SDK's union of member types.
+
+type AttributeValue struct {
+	N  *string
+	S  *string
+	SS []*string
+}
+
+type AttributeDefinition struct {
+	AttributeName *string
+	AttributeType *string
+}
+
+type KeySchemaElement struct {
+	AttributeName *string
+	KeyType       *string
+}
+
+type TableDescription struct {
+	TableName   *string
+	TableStatus *string
+}
+
+type CreateTableInput struct {
+	AttributeDefinitions []*AttributeDefinition
+	BillingMode          *string
+	KeySchema            []*KeySchemaElement
+	TableName            *string
+}
+
+type CreateTableOutput struct {
+	TableDescription *TableDescription
+}
+
+type DescribeTableInput struct {
+	TableName *string
+}
+
+type DescribeTableOutput struct {
+	Table *TableDescription
+}
+
+type GetItemInput struct {
+	ConsistentRead *bool
+	Key            map[string]*AttributeValue
+	TableName      *string
+}
+
+type GetItemOutput struct {
+	Item map[string]*AttributeValue
+}
+
+type PutItemInput struct {
+	ConditionExpression       *string
+	ExpressionAttributeNames  map[string]*string
+	ExpressionAttributeValues map[string]*AttributeValue
+	Item                      map[string]*AttributeValue
+	TableName                 *string
+}
+
+type PutItemOutput struct{}
+
+type UpdateItemInput struct {
+	ConditionExpression       *string
+	ExpressionAttributeNames  map[string]*string
+	ExpressionAttributeValues map[string]*AttributeValue
+	Key                       map[string]*AttributeValue
+	ReturnValues              *string
+	TableName                 *string
+	UpdateExpression          *string
+}
+
+type UpdateItemOutput struct {
+	Attributes map[string]*AttributeValue
+}
+
+type ScanInput struct {
+	ConsistentRead    *bool
+	ExclusiveStartKey map[string]*AttributeValue
+	TableName         *string
+}
+
+type ScanOutput struct {
+	Items            []map[string]*AttributeValue
+	LastEvaluatedKey map[string]*AttributeValue
+}
+
+// S returns a string attribute value.
+func S(s string) *AttributeValue {
+	return &AttributeValue{S: &s}
+}
+
+// N returns a number attribute value.
+func N(n int64) *AttributeValue {
+	return &AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+}
+
+// StringValue returns the string value of an attribute, or "" if it isn't set.
+func StringValue(v *AttributeValue) string {
+	if v == nil {
+		return ""
+	}
+	return aws.ToString(v.S)
+}
+
+// Int64Value returns the number value of an attribute, or 0 if it isn't set.
+func Int64Value(v *AttributeValue) int64 {
+	if v == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(aws.ToString(v.N), 10, 64)
+	return n
+}
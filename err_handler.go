@@ -1,17 +1,26 @@
 package kinesumer
 
 import (
-	"fmt"
+	"os"
 
 	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/loggers/text"
 )
 
+// DefaultLogger prints messages to stdout as "severity: message", followed by their fields.
+var DefaultLogger k.Logger = textlogger.New(os.Stdout)
+
+// DefaultErrHandler prints errors to stdout with DefaultLogger. Errors that stop a shard worker are
+// also sent on Kinesumer.Errors, and critical errors are returned by Kinesumer.Run.
 func DefaultErrHandler(err k.Error) {
-	fmt.Println(err.Severity()+":", err.Error())
+	k.LogError(DefaultLogger, err)
+}
 
-	severity := err.Severity()
-	if severity == ECrit || severity == EError {
-		panic(err)
+// LogErrHandler returns an error handler that logs errors to logger, with fields such as the
+// stream, shard and sequence number they occurred at.
+func LogErrHandler(logger k.Logger) func(k.Error) {
+	return func(err k.Error) {
+		k.LogError(logger, err)
 	}
 }
 
@@ -13,6 +13,8 @@ type Error struct {
 	severity string
 	message  string
 	origin   error
+	// Key/value pairs describing where the error occurred, such as the stream and shard.
+	fields []interface{}
 }
 
 func NewError(severity, message string, origin error) *Error {
@@ -23,6 +25,16 @@ func NewError(severity, message string, origin error) *Error {
 	}
 }
 
+// With adds key/value fields to the error, which are logged along with it.
+func (e *Error) With(keyvals ...interface{}) *Error {
+	e.fields = append(e.fields, keyvals...)
+	return e
+}
+
+func (e *Error) Fields() []interface{} {
+	return e.fields
+}
+
 func (e *Error) Severity() string {
 	return e.severity
 }
@@ -0,0 +1,186 @@
+// Package fanout implements enhanced fan-out with the Kinesis client's RegisterStreamConsumer and
+// SubscribeToShard operations.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// DefaultPollInterval is how often RegisterStreamConsumer checks whether a newly registered
+// consumer has become active.
+const DefaultPollInterval = time.Second
+
+// API is the set of Kinesis operations used for enhanced fan-out, so that they can be mocked. The
+// SDK's *kinesis.Client implements it.
+type API interface {
+	DescribeStreamSummary(ctx context.Context, input *kinesis.DescribeStreamSummaryInput, optFns ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error)
+	RegisterStreamConsumer(ctx context.Context, input *kinesis.RegisterStreamConsumerInput, optFns ...func(*kinesis.Options)) (*kinesis.RegisterStreamConsumerOutput, error)
+	DescribeStreamConsumer(ctx context.Context, input *kinesis.DescribeStreamConsumerInput, optFns ...func(*kinesis.Options)) (*kinesis.DescribeStreamConsumerOutput, error)
+	SubscribeToShard(ctx context.Context, input *kinesis.SubscribeToShardInput, optFns ...func(*kinesis.Options)) (*kinesis.SubscribeToShardOutput, error)
+}
+
+// Client implements kinesumeriface.FanOut. SubscribeToShard only works over HTTP/2, which the
+// default HTTP client negotiates with TLS endpoints.
+type Client struct {
+	Kinesis      API
+	PollInterval time.Duration
+}
+
+func New(kinesis API) *Client {
+	return &Client{
+		Kinesis:      kinesis,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// RegisterStreamConsumer registers the consumer if it isn't already, and then waits for it to
+// become active. stream is the name or the ARN of the stream.
+func (c *Client) RegisterStreamConsumer(ctx context.Context, stream, consumerName string) (string, error) {
+	streamARN := stream
+	if !strings.HasPrefix(stream, "arn:") {
+		desc, err := c.Kinesis.DescribeStreamSummary(ctx, &kinesis.DescribeStreamSummaryInput{
+			StreamName: &stream,
+		})
+		if err != nil {
+			return "", err
+		}
+		streamARN = aws.ToString(desc.StreamDescriptionSummary.StreamARN)
+	}
+
+	_, err := c.Kinesis.RegisterStreamConsumer(ctx, &kinesis.RegisterStreamConsumerInput{
+		ConsumerName: &consumerName,
+		StreamARN:    &streamARN,
+	})
+	var inUse *types.ResourceInUseException
+	if errors.As(err, &inUse) {
+		// The consumer was registered by another instance of the application.
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		out, err := c.Kinesis.DescribeStreamConsumer(ctx, &kinesis.DescribeStreamConsumerInput{
+			ConsumerName: &consumerName,
+			StreamARN:    &streamARN,
+		})
+		if err != nil {
+			return "", err
+		}
+		if out.ConsumerDescription != nil && out.ConsumerDescription.ConsumerStatus == types.ConsumerStatusActive {
+			return aws.ToString(out.ConsumerDescription.ConsumerARN), nil
+		}
+
+		select {
+		case <-time.After(c.PollInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// SubscribeToShard opens an event stream on the shard. The subscription is bound to ctx as well
+// as Close.
+func (c *Client) SubscribeToShard(ctx context.Context, consumerARN, shardID string, position k.StartingPosition) (k.ShardSubscription, error) {
+	start := &types.StartingPosition{Type: types.ShardIteratorType(position.Type)}
+	if len(position.SequenceNumber) > 0 {
+		start.SequenceNumber = &position.SequenceNumber
+	}
+	if !position.Timestamp.IsZero() {
+		start.Timestamp = &position.Timestamp
+	}
+
+	out, err := c.Kinesis.SubscribeToShard(ctx, &kinesis.SubscribeToShardInput{
+		ConsumerARN:      &consumerARN,
+		ShardId:          &shardID,
+		StartingPosition: start,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subscription{
+		stream: out.GetStream(),
+		events: make(chan *k.SubscribeToShardEvent),
+		closed: make(chan struct{}),
+	}
+	go sub.read()
+	return sub, nil
+}
+
+type subscription struct {
+	stream    *kinesis.SubscribeToShardEventStream
+	events    chan *k.SubscribeToShardEvent
+	err       error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *subscription) Events() <-chan *k.SubscribeToShardEvent {
+	return s.events
+}
+
+func (s *subscription) Err() error {
+	return s.err
+}
+
+func (s *subscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.stream.Close()
+	})
+	return err
+}
+
+// read sends the events pushed on the stream until it ends.
+func (s *subscription) read() {
+	defer close(s.events)
+
+	for {
+		var event types.SubscribeToShardEventStream
+		var ok bool
+		select {
+		case event, ok = <-s.stream.Events():
+		case <-s.closed:
+			return
+		}
+		if !ok {
+			s.fail(s.stream.Err())
+			return
+		}
+		records, ok := event.(*types.SubscribeToShardEventStreamMemberSubscribeToShardEvent)
+		if !ok {
+			continue
+		}
+
+		select {
+		case s.events <- &k.SubscribeToShardEvent{
+			Records:                    records.Value.Records,
+			ContinuationSequenceNumber: aws.ToString(records.Value.ContinuationSequenceNumber),
+			MillisBehindLatest:         aws.ToInt64(records.Value.MillisBehindLatest),
+		}:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// fail records the error that ended the stream, unless it ended because it was closed.
+func (s *subscription) fail(err error) {
+	select {
+	case <-s.closed:
+	default:
+		s.err = err
+	}
+}
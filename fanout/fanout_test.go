@@ -0,0 +1,202 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testStreamARN   = "arn:aws:kinesis:us-east-1:123456789012:stream/stream"
+	testConsumerARN = testStreamARN + "/consumer/app:1"
+)
+
+// writeMessage encodes an event stream message with string headers.
+func writeMessage(w io.Writer, headers map[string]string, payload []byte) {
+	msg := eventstream.Message{Payload: payload}
+	for name, value := range headers {
+		msg.Headers.Set(name, eventstream.StringValue(value))
+	}
+	eventstream.NewEncoder().Encode(w, msg)
+}
+
+func writeEvent(w io.Writer, event interface{}) {
+	payload, _ := json.Marshal(event)
+	writeMessage(w, map[string]string{
+		":message-type": "event",
+		":event-type":   "SubscribeToShardEvent",
+	}, payload)
+}
+
+type testServer struct {
+	*httptest.Server
+	describeCalls         int
+	describeConsumerCalls int
+	registerInput         map[string]interface{}
+	subscribeInput        map[string]interface{}
+	subscribe             func(w http.ResponseWriter)
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{}
+	ts.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Amz-Target") {
+		case "Kinesis_20131202.DescribeStreamSummary":
+			ts.describeCalls++
+			w.Write([]byte(`{"StreamDescriptionSummary":{"StreamARN":"` + testStreamARN + `","StreamName":"stream","StreamStatus":"ACTIVE"}}`))
+		case "Kinesis_20131202.RegisterStreamConsumer":
+			json.NewDecoder(r.Body).Decode(&ts.registerInput)
+			w.WriteHeader(400)
+			w.Write([]byte(`{"__type":"ResourceInUseException","message":"Consumer already exists"}`))
+		case "Kinesis_20131202.DescribeStreamConsumer":
+			ts.describeConsumerCalls++
+			status := "CREATING"
+			if ts.describeConsumerCalls > 1 {
+				status = "ACTIVE"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ConsumerDescription": map[string]string{
+					"ConsumerARN":    testConsumerARN,
+					"ConsumerStatus": status,
+				},
+			})
+		case "Kinesis_20131202.SubscribeToShard":
+			assert.Equal(t, 2, r.ProtoMajor)
+			json.NewDecoder(r.Body).Decode(&ts.subscribeInput)
+			w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+			writeMessage(w, map[string]string{
+				":message-type": "event",
+				":event-type":   "initial-response",
+			}, []byte("{}"))
+			ts.subscribe(w)
+		default:
+			t.Errorf("unexpected request %s", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts
+}
+
+func (ts *testServer) client() *Client {
+	c := New(kinesis.New(kinesis.Options{
+		BaseEndpoint:     aws.String(ts.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		HTTPClient:       ts.Client(),
+		Region:           "us-east-1",
+		RetryMaxAttempts: 1,
+	}))
+	c.PollInterval = time.Millisecond
+	return c
+}
+
+func TestRegisterStreamConsumer(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	arn, err := ts.client().RegisterStreamConsumer(context.Background(), "stream", "app")
+	assert.NoError(t, err)
+	assert.Equal(t, testConsumerARN, arn)
+	assert.Equal(t, 1, ts.describeCalls)
+	assert.Equal(t, 2, ts.describeConsumerCalls)
+	assert.Equal(t, testStreamARN, ts.registerInput["StreamARN"])
+}
+
+func TestRegisterStreamConsumerARN(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	// A stream given by ARN doesn't need to be described to find it.
+	arn, err := ts.client().RegisterStreamConsumer(context.Background(), testStreamARN, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, testConsumerARN, arn)
+	assert.Equal(t, 0, ts.describeCalls)
+	assert.Equal(t, testStreamARN, ts.registerInput["StreamARN"])
+}
+
+func TestSubscribeToShard(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.subscribe = func(w http.ResponseWriter) {
+		writeEvent(w, map[string]interface{}{
+			"ContinuationSequenceNumber": "2",
+			"MillisBehindLatest":         100,
+			"Records": []map[string]interface{}{
+				{"Data": []byte("a"), "PartitionKey": "pk", "SequenceNumber": "1"},
+				{"Data": []byte("b"), "PartitionKey": "pk", "SequenceNumber": "2", "EncryptionType": "KMS", "ApproximateArrivalTimestamp": 1500000000},
+			},
+		})
+		writeEvent(w, map[string]interface{}{
+			"MillisBehindLatest": 0,
+			"Records":            []interface{}{},
+		})
+	}
+
+	sub, err := ts.client().SubscribeToShard(context.Background(), testConsumerARN, "shard0", k.StartingPosition{
+		Type:           "AFTER_SEQUENCE_NUMBER",
+		SequenceNumber: "0",
+	})
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	event := <-sub.Events()
+	assert.Equal(t, "2", event.ContinuationSequenceNumber)
+	assert.Equal(t, int64(100), event.MillisBehindLatest)
+	assert.Equal(t, 2, len(event.Records))
+	assert.Equal(t, []byte("b"), event.Records[1].Data)
+	assert.Equal(t, "2", aws.ToString(event.Records[1].SequenceNumber))
+	assert.Equal(t, types.EncryptionType(""), event.Records[0].EncryptionType)
+	assert.Equal(t, types.EncryptionTypeKms, event.Records[1].EncryptionType)
+	assert.Equal(t, int64(1500000000), aws.ToTime(event.Records[1].ApproximateArrivalTimestamp).Unix())
+
+	event = <-sub.Events()
+	assert.Equal(t, "", event.ContinuationSequenceNumber)
+	assert.Equal(t, 0, len(event.Records))
+
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	assert.NoError(t, sub.Err())
+
+	assert.Equal(t, "shard0", ts.subscribeInput["ShardId"])
+	assert.Equal(t, map[string]interface{}{
+		"Type":           "AFTER_SEQUENCE_NUMBER",
+		"SequenceNumber": "0",
+	}, ts.subscribeInput["StartingPosition"])
+}
+
+func TestSubscribeToShardException(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.subscribe = func(w http.ResponseWriter) {
+		writeMessage(w, map[string]string{
+			":message-type":   "exception",
+			":exception-type": "ResourceNotFoundException",
+		}, []byte(`{"message":"Shard not found"}`))
+	}
+
+	sub, err := ts.client().SubscribeToShard(context.Background(), testConsumerARN, "shard0", k.StartingPosition{
+		Type: "LATEST",
+	})
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	var notFound *types.ResourceNotFoundException
+	if assert.True(t, errors.As(sub.Err(), &notFound)) {
+		assert.Equal(t, "Shard not found", notFound.ErrorMessage())
+	}
+}
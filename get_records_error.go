@@ -0,0 +1,90 @@
+package kinesumer
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// GetRecordsErrorClass is the kind of failure a GetRecords request ran into, which decides how a
+// shard worker recovers from it.
+type GetRecordsErrorClass int
+
+const (
+	// GetRecordsErrorUnknown is any failure that isn't classified. The worker gets a new iterator
+	// and carries on reading the shard.
+	GetRecordsErrorUnknown GetRecordsErrorClass = iota
+	// GetRecordsErrorExpiredIterator means the iterator wasn't used within 5 minutes of being got.
+	// The worker gets a new one from the last record it read.
+	GetRecordsErrorExpiredIterator
+	// GetRecordsErrorThrottled means the shard's read limits, or those of the KMS key the stream
+	// is encrypted with, were exceeded. The iterator is used again once the throttle backs off.
+	GetRecordsErrorThrottled
+	// GetRecordsErrorKMS means the records couldn't be decrypted because of how the stream's KMS
+	// key is set up, which retrying won't fix. The worker stops.
+	GetRecordsErrorKMS
+	// GetRecordsErrorResourceNotFound means the stream or shard no longer exists. The worker stops.
+	GetRecordsErrorResourceNotFound
+	// GetRecordsErrorTransient is a network failure or an error on the service's side. The
+	// iterator is used again once the throttle backs off.
+	GetRecordsErrorTransient
+)
+
+var getRecordsErrorClasses = map[string]GetRecordsErrorClass{
+	"ExpiredIteratorException":               GetRecordsErrorExpiredIterator,
+	"ProvisionedThroughputExceededException": GetRecordsErrorThrottled,
+	"KMSThrottlingException":                 GetRecordsErrorThrottled,
+	"ResourceNotFoundException":              GetRecordsErrorResourceNotFound,
+	"InternalFailure":                        GetRecordsErrorTransient,
+	"InternalFailureException":               GetRecordsErrorTransient,
+	"ServiceUnavailable":                     GetRecordsErrorTransient,
+}
+
+func (c GetRecordsErrorClass) String() string {
+	switch c {
+	case GetRecordsErrorExpiredIterator:
+		return "expired iterator"
+	case GetRecordsErrorThrottled:
+		return "throttled"
+	case GetRecordsErrorKMS:
+		return "kms"
+	case GetRecordsErrorResourceNotFound:
+		return "resource not found"
+	case GetRecordsErrorTransient:
+		return "transient"
+	}
+	return "unknown"
+}
+
+// ClassifyGetRecordsError returns the class of an error returned by a GetRecords request.
+func ClassifyGetRecordsError(err error) GetRecordsErrorClass {
+	if code := errorCode(err); len(code) > 0 {
+		if class, ok := getRecordsErrorClasses[code]; ok {
+			return class
+		}
+		// KMSAccessDeniedException, KMSDisabledException, KMSInvalidStateException,
+		// KMSNotFoundException and KMSOptInRequired.
+		if strings.HasPrefix(code, "KMS") {
+			return GetRecordsErrorKMS
+		}
+		return GetRecordsErrorUnknown
+	}
+	var sendErr *smithyhttp.RequestSendError
+	var netErr net.Error
+	if errors.As(err, &sendErr) || errors.As(err, &netErr) {
+		return GetRecordsErrorTransient
+	}
+	return GetRecordsErrorUnknown
+}
+
+// errorCode returns the code of an error returned by an AWS API, or "" if err isn't one.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package kinesumer
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func apiError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
+
+func TestClassifyGetRecordsError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class GetRecordsErrorClass
+	}{
+		{apiError("ExpiredIteratorException", "expired"), GetRecordsErrorExpiredIterator},
+		{&smithy.OperationError{
+			ServiceID:     "Kinesis",
+			OperationName: "GetRecords",
+			Err:           &types.ExpiredIteratorException{Message: aws.String("expired")},
+		}, GetRecordsErrorExpiredIterator},
+		{apiError("ProvisionedThroughputExceededException", "slow down"), GetRecordsErrorThrottled},
+		{apiError("KMSThrottlingException", "slow down"), GetRecordsErrorThrottled},
+		{apiError("KMSAccessDeniedException", "denied"), GetRecordsErrorKMS},
+		{apiError("KMSDisabledException", "disabled"), GetRecordsErrorKMS},
+		{apiError("ResourceNotFoundException", "gone"), GetRecordsErrorResourceNotFound},
+		{&smithyhttp.RequestSendError{Err: errors.New("reset")}, GetRecordsErrorTransient},
+		{apiError("InternalFailure", "oops"), GetRecordsErrorTransient},
+		{&net.DNSError{Err: "no such host"}, GetRecordsErrorTransient},
+		{apiError("ValidationException", "bad"), GetRecordsErrorUnknown},
+		{errors.New("bad"), GetRecordsErrorUnknown},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.class, ClassifyGetRecordsError(tt.err), tt.err.Error())
+	}
+}
@@ -0,0 +1,21 @@
+package kinesumer
+
+import (
+	"context"
+
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, shardID string, records []k.Record) error
+
+func (f HandlerFunc) HandleBatch(ctx context.Context, shardID string, records []k.Record) error {
+	return f(ctx, shardID, records)
+}
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc func(ctx context.Context, record k.Record) error
+
+func (f ProcessorFunc) Process(ctx context.Context, record k.Record) error {
+	return f(ctx, record)
+}
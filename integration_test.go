@@ -1,17 +1,20 @@
+//go:build integration
 // +build integration
 
 package kinesumer
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/pborman/uuid"
 	"github.com/remind101/kinesumer/checkpointers/redis"
@@ -75,10 +78,10 @@ func TestIntegration(t *testing.T) {
 	rand.Seed(94133)
 
 	fmt.Println("Creating Kinesis")
-	kin := kinesis.New(&aws.Config{
-		Credentials: credentials.NewStaticCredentials(os.Getenv("AWS_ACCESS_KEY_ID"),
+	kin := kinesis.New(kinesis.Options{
+		Credentials: credentials.NewStaticCredentialsProvider(os.Getenv("AWS_ACCESS_KEY_ID"),
 			os.Getenv("AWS_SECRET_ACCESS_KEY"), ""),
-		Region: aws.String(os.Getenv("AWS_REGION")),
+		Region: os.Getenv("AWS_REGION"),
 	})
 
 	stream := os.Getenv("AWS_KINESIS_STREAM")
@@ -120,19 +123,19 @@ func TestIntegration(t *testing.T) {
 	}
 
 	fmt.Println("Creating Kinesumer")
-	k, err := New(kin, cp, prov, nil, stream, &kinOpt)
+	k, err := New(kin, cp, prov, nil, stream, &kinOpt, 0)
 	if err != nil {
 		panic(err)
 	}
 
-	exists, err := k.StreamExists()
+	exists, err := k.StreamExists(context.Background())
 	if err != nil {
 		panic(err)
 	}
 
 	if exists {
 		fmt.Println("Deleting stream")
-		_, err := kin.DeleteStream(&kinesis.DeleteStreamInput{
+		_, err := kin.DeleteStream(context.Background(), &kinesis.DeleteStreamInput{
 			StreamName: aws.String(stream),
 		})
 		if err != nil {
@@ -141,7 +144,7 @@ func TestIntegration(t *testing.T) {
 
 		for i := 0; i < 60; i++ {
 			time.Sleep(time.Second)
-			if exists, err := k.StreamExists(); err != nil {
+			if exists, err := k.StreamExists(context.Background()); err != nil {
 				panic(err)
 			} else if !exists {
 				goto cont1
@@ -152,8 +155,8 @@ func TestIntegration(t *testing.T) {
 cont1:
 
 	fmt.Println("Creating stream")
-	_, err = kin.CreateStream(&kinesis.CreateStreamInput{
-		ShardCount: aws.Int64(3),
+	_, err = kin.CreateStream(context.Background(), &kinesis.CreateStreamInput{
+		ShardCount: aws.Int32(3),
 		StreamName: aws.String(stream),
 	})
 	if err != nil {
@@ -162,7 +165,7 @@ cont1:
 
 	for i := 0; i < 60; i++ {
 		time.Sleep(time.Second)
-		if exists, err := k.StreamExists(); err != nil {
+		if exists, err := k.StreamExists(context.Background()); err != nil {
 			panic(err)
 		} else if exists {
 			goto cont2
@@ -179,7 +182,7 @@ cont2:
 
 	time.Sleep(8 * time.Second)
 
-	if len(workers) != 2 {
+	if workers != 2 {
 		panic(fmt.Sprintf("Expected 2 workers to be started by k. Workers: %v",
 			workers,
 		))
@@ -197,7 +200,7 @@ cont2:
 
 	kinOpt2 := kinOpt
 	kinOpt2.MaxShardWorkers = 3
-	k2, err := New(kin, cp2, prov2, nil, stream, &kinOpt2)
+	k2, err := New(kin, cp2, prov2, nil, stream, &kinOpt2, 0)
 	if err != nil {
 		panic(err)
 	}
@@ -207,29 +210,29 @@ cont2:
 		panic(err)
 	}
 
-	if len(workers2) != 1 {
+	if workers2 != 1 {
 		panic(fmt.Sprintf("Expected 1 worker to be started by k2. Workers: %v", workers2))
 	}
 
-	records := make([]*kinesis.PutRecordsRequestEntry, 100)
+	records := make([]types.PutRecordsRequestEntry, 100)
 	values := make(map[string]bool)
 	for i := 0; i < 100; i++ {
 		item := uuid.New()
 		values[item] = true
-		records[i] = &kinesis.PutRecordsRequestEntry{
+		records[i] = types.PutRecordsRequestEntry{
 			Data:         []byte(item),
 			PartitionKey: aws.String(item),
 		}
 	}
 
-	res, err := kin.PutRecords(&kinesis.PutRecordsInput{
+	res, err := kin.PutRecords(context.Background(), &kinesis.PutRecordsInput{
 		Records:    records,
 		StreamName: aws.String(stream),
 	})
 	if err != nil {
 		panic(err)
 	}
-	if aws.Int64Value(res.FailedRecordCount) != 0 {
+	if aws.ToInt32(res.FailedRecordCount) != 0 {
 		panic(fmt.Sprintf("Failed to put records: %v", res.Records))
 	}
 
@@ -269,14 +272,14 @@ cont2:
 			case <-stopC:
 			default:
 				time.Sleep(time.Second)
-				res, err := kin.PutRecords(&kinesis.PutRecordsInput{
+				res, err := kin.PutRecords(context.Background(), &kinesis.PutRecordsInput{
 					Records:    smallRecords,
 					StreamName: aws.String(stream),
 				})
 				if err != nil {
 					panic(err)
 				}
-				if aws.Int64Value(res.FailedRecordCount) != 0 {
+				if aws.ToInt32(res.FailedRecordCount) != 0 {
 					panic(fmt.Sprintf("Failed to put records: %v", res.Records))
 				}
 			}
@@ -294,7 +297,7 @@ cont2:
 		}
 	}()
 
-	shards, err := k.GetShards()
+	shards, err := k.GetShards(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -314,7 +317,7 @@ cont2:
 	for _, pair := range pairs {
 		if isConsecutive(*shards[pair.begin].HashKeyRange.EndingHashKey,
 			*shards[pair.end].HashKeyRange.StartingHashKey) {
-			_, err := kin.MergeShards(&kinesis.MergeShardsInput{
+			_, err := kin.MergeShards(context.Background(), &kinesis.MergeShardsInput{
 				ShardToMerge:         shards[pair.begin].ShardId,
 				AdjacentShardToMerge: shards[pair.end].ShardId,
 				StreamName:           aws.String(stream),
@@ -327,12 +330,12 @@ cont2:
 	}
 	panic(func() string {
 		s := "Could not find shard to close. Shards: "
-		shards, err := k.GetShards()
+		shards, err := k.GetShards(context.Background())
 		if err != nil {
 			return err.Error()
 		}
 		for _, shard := range shards {
-			s += shard.GoString()
+			s += fmt.Sprintf("%+v", shard)
 		}
 		return s
 	}(),
@@ -343,10 +346,10 @@ cont3:
 	select {
 	case <-timeout.C:
 		panic("Shard worker did not stop after shard closed")
-	case <-k.stopped:
-		k.stopped <- Unit{}
-	case <-k2.stopped:
-		k2.stopped <- Unit{}
+	case w := <-k.stopped:
+		k.stopped <- w
+	case w := <-k2.stopped:
+		k2.stopped <- w
 	}
 
 	stopC <- struct{}{}
@@ -358,7 +361,7 @@ cont3:
 	time.Sleep(time.Second)
 
 	fmt.Println("Deleting stream")
-	_, err = kin.DeleteStream(&kinesis.DeleteStreamInput{
+	_, err = kin.DeleteStream(context.Background(), &kinesis.DeleteStreamInput{
 		StreamName: aws.String(stream),
 	})
 	if err != nil {
@@ -367,7 +370,7 @@ cont3:
 
 	for i := 0; i < 60; i++ {
 		time.Sleep(time.Second)
-		if exists, err := k.StreamExists(); err != nil {
+		if exists, err := k.StreamExists(context.Background()); err != nil {
 			panic(err)
 		} else if !exists {
 			return
@@ -1,9 +1,53 @@
 package kinesumeriface
 
+import (
+	"context"
+)
+
+// ShardEnd is the sequence number checkpointed for a shard once it has been closed and every
+// record in it has been marked done. Child shards are only started once all of their parents
+// have reached ShardEnd.
+const ShardEnd = "SHARD_END"
+
 type Checkpointer interface {
+	// Track registers a record before it is handed to the consumer, so that the shard's
+	// checkpoint isn't advanced past it until it has been marked done.
+	Track(record Record)
 	DoneC() chan<- Record
-	Begin() error
+	Begin(ctx context.Context) error
+	// End saves the checkpoints and closes DoneC.
 	End()
-	GetStartSequence(shardID string) string
+	// GetStartSequence returns the shard's checkpoint, or "" if it has none. It returns an error
+	// if the checkpoint couldn't be read, so that the shard isn't read from the default iterator
+	// type past records that were never checkpointed.
+	GetStartSequence(ctx context.Context, shardID string) (string, error)
+	// Sync saves the checkpoints, including those of records whose send to DoneC has returned.
 	Sync()
 }
+
+// Fencer is implemented by checkpointers that can stop checkpointing a shard whose lease was lost,
+// so that records that are still being processed can't move the checkpoint of a shard that
+// another consumer may now own.
+type Fencer interface {
+	// Revoke discards the shard's in flight records and any checkpoint that hasn't been saved,
+	// and ignores records of the lease epoch, or an earlier one, from then on.
+	Revoke(shardID string, epoch int64)
+}
+
+// ShardKey returns the key that a shard of a stream is locked and checkpointed under by a consumer
+// reading several streams, whose shard IDs overlap.
+func ShardKey(stream, shardID string) string {
+	return stream + ":" + shardID
+}
+
+// CheckpointKey returns the key that a record's shard is checkpointed under. It is the record's
+// shard ID unless the record was read by a consumer of several streams, in which case it is the
+// ShardKey of its stream and shard.
+func CheckpointKey(record Record) string {
+	if keyed, ok := record.(interface {
+		CheckpointKey() string
+	}); ok {
+		return keyed.CheckpointKey()
+	}
+	return record.ShardId()
+}
@@ -0,0 +1,11 @@
+package kinesumeriface
+
+import (
+	"context"
+)
+
+// DeadLetter parks records that keep failing to be processed, along with the reason they failed,
+// so that their shard can carry on past them.
+type DeadLetter interface {
+	Park(ctx context.Context, record Record, reason error) error
+}
@@ -0,0 +1,7 @@
+package kinesumeriface
+
+import (
+	"github.com/remind101/kinesumer/dynamodbclient"
+)
+
+type DynamoDB dynamodbclient.API
@@ -13,3 +13,10 @@ type Error interface {
 	Origin() error
 	Error() string
 }
+
+// FieldsError is implemented by errors that carry key/value fields describing where they
+// occurred, which are logged along with them.
+type FieldsError interface {
+	Error
+	Fields() []interface{}
+}
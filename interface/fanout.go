@@ -0,0 +1,49 @@
+package kinesumeriface
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// FanOut reads shards with enhanced fan-out, where each registered consumer gets its own read
+// throughput pushed to it over an HTTP/2 event stream instead of polling GetRecords.
+type FanOut interface {
+	// RegisterStreamConsumer registers a consumer on the stream, or finds the one already
+	// registered under that name, and returns its ARN once it is active.
+	RegisterStreamConsumer(ctx context.Context, stream, consumerName string) (string, error)
+
+	// SubscribeToShard starts pushing records from the shard to the consumer. Kinesis ends
+	// subscriptions after 5 minutes.
+	SubscribeToShard(ctx context.Context, consumerARN, shardID string, position StartingPosition) (ShardSubscription, error)
+}
+
+// StartingPosition is where a subscription starts reading a shard. Type is a shard iterator type
+// such as "AFTER_SEQUENCE_NUMBER" or "LATEST".
+type StartingPosition struct {
+	Type           string
+	SequenceNumber string
+	Timestamp      time.Time
+}
+
+// SubscribeToShardEvent is a batch of records pushed on a subscription.
+// ContinuationSequenceNumber is where to resubscribe to carry on from this event, and is empty
+// once the shard has been read to its end.
+type SubscribeToShardEvent struct {
+	Records                    []types.Record
+	ContinuationSequenceNumber string
+	MillisBehindLatest         int64
+}
+
+type ShardSubscription interface {
+	// Events returns the channel that events are pushed on. It is closed when the subscription
+	// ends.
+	Events() <-chan *SubscribeToShardEvent
+
+	// Err returns the error that ended the subscription, if any, once Events is closed.
+	Err() error
+
+	// Close ends the subscription.
+	Close() error
+}
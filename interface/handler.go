@@ -0,0 +1,17 @@
+package kinesumeriface
+
+import (
+	"context"
+)
+
+// Handler processes the records returned by a single GetRecords call on a shard. Returning nil
+// checkpoints the last record in the batch, and returning an error retries the batch.
+type Handler interface {
+	HandleBatch(ctx context.Context, shardID string, records []Record) error
+}
+
+// Processor processes a single record. Returning nil marks the record done, so that its shard's
+// checkpoint can advance past it.
+type Processor interface {
+	Process(ctx context.Context, record Record) error
+}
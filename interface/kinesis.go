@@ -1,7 +1,23 @@
 package kinesumeriface
 
 import (
-	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 )
 
-type Kinesis kinesisiface.KinesisAPI
+// Kinesis is the set of Kinesis operations that consumers use, so that they can be mocked. The
+// SDK's *kinesis.Client implements it.
+type Kinesis interface {
+	ListStreams(ctx context.Context, input *kinesis.ListStreamsInput, optFns ...func(*kinesis.Options)) (*kinesis.ListStreamsOutput, error)
+	DescribeStream(ctx context.Context, input *kinesis.DescribeStreamInput, optFns ...func(*kinesis.Options)) (*kinesis.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, input *kinesis.GetShardIteratorInput, optFns ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, input *kinesis.GetRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error)
+}
+
+// KinesisPutter is the set of Kinesis operations that producers and dead letters use. The SDK's
+// *kinesis.Client implements it.
+type KinesisPutter interface {
+	PutRecord(ctx context.Context, input *kinesis.PutRecordInput, optFns ...func(*kinesis.Options)) (*kinesis.PutRecordOutput, error)
+	PutRecords(ctx context.Context, input *kinesis.PutRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error)
+}
@@ -1,7 +1,23 @@
 package kinesumeriface
 
+import (
+	"context"
+)
+
 type Kinesumer interface {
+	Run(ctx context.Context) error
 	Begin() (int, error)
 	End()
 	Records() <-chan Record
+	Shards() <-chan ShardStream
+	Revoked() <-chan Revocation
+	Errors() <-chan Error
+}
+
+// Revocation is sent when the lease on a shard is lost while it's being worked on. The shard's
+// records with the lease epoch won't be checkpointed, so their processing can be abandoned.
+type Revocation struct {
+	StreamName string
+	ShardID    string
+	LeaseEpoch int64
 }
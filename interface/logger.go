@@ -0,0 +1,17 @@
+package kinesumeriface
+
+// Logger receives messages along with key/value fields describing where they came from, such as
+// the stream and shard, in the style of log/slog. The severity is one of ECrit, EError, EWarn,
+// EInfo and EDebug.
+type Logger interface {
+	Log(severity, msg string, keyvals ...interface{})
+}
+
+// LogError logs err at its severity, with its fields if it has any.
+func LogError(logger Logger, err Error) {
+	var keyvals []interface{}
+	if fields, ok := err.(FieldsError); ok {
+		keyvals = fields.Fields()
+	}
+	logger.Log(err.Severity(), err.Error(), keyvals...)
+}
@@ -0,0 +1,25 @@
+package kinesumeriface
+
+import (
+	"time"
+)
+
+// Metrics is told about the work of a Kinesumer and its checkpointer, to export it to a monitoring
+// system. Its methods are called from many goroutines at once.
+type Metrics interface {
+	// RecordsRead is called with each batch of records read from a shard.
+	RecordsRead(shardID string, records, bytes int, millisBehindLatest int64)
+	// GetRecords is called after each GetRecords request, with how long it took.
+	GetRecords(shardID string, latency time.Duration, err error)
+	// CheckpointSaved is called after checkpoints are written, with how long it took.
+	CheckpointSaved(latency time.Duration, err error)
+	// LockAcquired is called after each attempt to acquire the lock on a shard.
+	LockAcquired(shardID string, err error)
+	// Heartbeat is called after each heartbeat on the lock on a shard.
+	Heartbeat(shardID string, err error)
+	// ShardsOwned is called with the number of shards being worked on each time it changes.
+	ShardsOwned(n int)
+	// ShardReleased is called once a shard's worker has released its lock, so that the shard's
+	// metrics can be dropped.
+	ShardReleased(shardID string)
+}
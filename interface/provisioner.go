@@ -1,12 +1,33 @@
 package kinesumeriface
 
 import (
+	"context"
 	"time"
 )
 
 type Provisioner interface {
-	TryAcquire(shardID string) error
-	Release(shardID string) error
-	Heartbeat(shardID string) error
+	TryAcquire(ctx context.Context, shardID string) error
+	Release(ctx context.Context, shardID string) error
+	Heartbeat(ctx context.Context, shardID string) error
 	TTL() time.Duration
 }
+
+// Balancer is implemented by provisioners that know how many consumers are sharing the stream, so
+// that its shards can be balanced between them.
+type Balancer interface {
+	// Consumers marks this consumer as live for at least ttl, and returns the number of live
+	// consumers, including this one.
+	Consumers(ctx context.Context, ttl time.Duration) (int, error)
+}
+
+// Stealer is implemented by provisioners that can take a shard's lock from the consumer holding
+// it, which loses the shard the next time it heartbeats.
+type Stealer interface {
+	Steal(ctx context.Context, shardID string) error
+}
+
+// Owner is implemented by provisioners that hold their locks under an ID, which is logged along
+// with the shard a message is about.
+type Owner interface {
+	Owner() string
+}
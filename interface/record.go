@@ -1,10 +1,59 @@
 package kinesumeriface
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Record interface {
 	Data() []byte
 	PartitionKey() string
 	SequenceNumber() string
+	// SubSequenceNumber is the index of a user record within the Kinesis record that the Kinesis
+	// Producer Library aggregated it into. It is 0 for records that weren't aggregated.
+	SubSequenceNumber() int64
+	// ExtendedSequenceNumber is the position that the record is checkpointed at. It is the
+	// sequence number of a record that wasn't aggregated, and JoinSequenceNumber of the sequence
+	// and sub-sequence numbers of a user record that was.
+	ExtendedSequenceNumber() string
 	ShardId() string
+	// StreamName is the stream that the record was read from, as the consumer was given it: its
+	// name, or its ARN if it's addressed by ARN.
+	StreamName() string
 	MillisBehindLatest() int64
+	// ApproximateArrivalTimestamp is when Kinesis accepted the record. User records aggregated
+	// into the same Kinesis record share it.
+	ApproximateArrivalTimestamp() time.Time
+	// EncryptionType is how the record was encrypted at rest, "NONE" or "KMS".
+	EncryptionType() string
+	// ExplicitHashKey is the hash key that the producer chose the record's shard with instead of
+	// hashing its partition key. Kinesis only keeps it for user records aggregated by the Kinesis
+	// Producer Library, and it is empty otherwise.
+	ExplicitHashKey() string
+	// LeaseEpoch identifies the lease on the shard that the record was read under. It increases
+	// each time the consumer acquires the shard, so that a checkpointer can ignore records read
+	// under a lease that has since been lost.
+	LeaseEpoch() int64
 	Done()
 }
+
+// JoinSequenceNumber returns the extended sequence number of a user record that was aggregated
+// into the Kinesis record with sequenceNumber.
+func JoinSequenceNumber(sequenceNumber string, subSequenceNumber int64) string {
+	return sequenceNumber + ":" + strconv.FormatInt(subSequenceNumber, 10)
+}
+
+// SplitSequenceNumber splits an extended sequence number into the sequence number of a Kinesis
+// record, and the sub-sequence number of a user record within it if it was aggregated.
+func SplitSequenceNumber(extended string) (sequenceNumber string, subSequenceNumber int64, aggregated bool) {
+	i := strings.IndexByte(extended, ':')
+	if i < 0 {
+		return extended, 0, false
+	}
+	sub, err := strconv.ParseInt(extended[i+1:], 10, 64)
+	if err != nil {
+		return extended, 0, false
+	}
+	return extended[:i], sub, true
+}
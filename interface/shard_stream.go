@@ -0,0 +1,18 @@
+package kinesumeriface
+
+// ShardStream delivers the records of a single shard that a Kinesumer has acquired, so that each
+// shard can be consumed by its own goroutine.
+type ShardStream interface {
+	StreamName() string
+	ShardID() string
+
+	// Records is closed once the worker on the shard has stopped.
+	Records() <-chan Record
+
+	// Ended reports, once Records is closed, whether the shard was read to its end.
+	Ended() bool
+
+	// Err returns, once Records is closed, why the worker stopped if the shard wasn't read to its
+	// end, such as its lock being lost or it being handed off to another consumer.
+	Err() error
+}
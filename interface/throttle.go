@@ -0,0 +1,21 @@
+package kinesumeriface
+
+import (
+	"context"
+)
+
+// Throttle paces the GetRecords calls that shard workers make, to keep each shard within its read
+// limits.
+type Throttle interface {
+	// Wait blocks until the next GetRecords call may be made on the shard, or ctx is done.
+	Wait(ctx context.Context, shardID string) error
+
+	// Observe tells the throttle how a GetRecords call on the shard went: how many bytes of
+	// records it returned, how far behind the tip of the shard the reader is, and whether the call
+	// was rejected because the shard's read limits were exceeded.
+	Observe(shardID string, bytes int, millisBehindLatest int64, throttled bool)
+
+	// ShardReleased is called once a shard's worker has released its lock, so that the shard's
+	// state can be dropped.
+	ShardReleased(shardID string)
+}
@@ -6,12 +6,28 @@ import (
 
 type ICheckpointer kinesumeriface.Checkpointer
 
+type IDeadLetter kinesumeriface.DeadLetter
+
+type IDynamoDB kinesumeriface.DynamoDB
+
 type IError kinesumeriface.Error
 
+type IHandler kinesumeriface.Handler
+
 type IKinesis kinesumeriface.Kinesis
 
+type IKinesisPutter kinesumeriface.KinesisPutter
+
 type IKinesumer kinesumeriface.Kinesumer
 
+type ILogger kinesumeriface.Logger
+
+type IMetrics kinesumeriface.Metrics
+
+type IProcessor kinesumeriface.Processor
+
 type IProvisioner kinesumeriface.Provisioner
 
 type IRecord kinesumeriface.Record
+
+type IShardStream kinesumeriface.ShardStream
@@ -1,16 +1,19 @@
 package kinesumer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"github.com/remind101/kinesumer/checkpointers/empty"
+	"github.com/remind101/kinesumer/fanout"
 	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/metrics/empty"
 	"github.com/remind101/kinesumer/provisioners/empty"
 )
 
@@ -22,19 +25,75 @@ const (
 	//
 	// See http://docs.aws.amazon.com/streams/latest/dev/service-sizes-and-limits.html
 	DefaultGetRecordsThrottle = 200 * time.Millisecond
+
+	// DefaultShardDiscoveryPeriod is how often the stream is described to find shards created by
+	// splits and merges.
+	DefaultShardDiscoveryPeriod = 30 * time.Second
+
+	// DefaultHandlerRetries is how many times a shard worker retries a batch that its Handler
+	// failed before giving up on it.
+	DefaultHandlerRetries = 3
+
+	// DefaultHandlerRetryBackoff is how long a shard worker waits before retrying a batch that
+	// its Handler failed. The wait doubles with each retry, up to maxHandlerRetryBackoff.
+	DefaultHandlerRetryBackoff = time.Second
+	maxHandlerRetryBackoff     = 30 * time.Second
+
+	// errorsBuffer is the capacity of the channel returned by Errors.
+	errorsBuffer = 100
 )
 
+var errStreamDeleting = errors.New("Stream is being deleted")
+
+// errWorkerLimit is returned by LaunchShardWorker when the MultiKinesumer that the Kinesumer
+// belongs to already has MaxShardWorkers workers across its streams.
+var errWorkerLimit = errors.New("Shard worker limit reached")
+
 type Kinesumer struct {
 	Kinesis      k.Kinesis
 	Checkpointer k.Checkpointer
 	Provisioner  k.Provisioner
-	Stream       string
 	Options      *Options
-	records      chan k.Record
-	stop         chan Unit
-	stopped      chan Unit
-	nRunning     int
-	rand         *rand.Rand
+
+	// Stream is the name or the ARN of the stream to read. Addressing a stream by ARN keeps apart
+	// streams with the same name in different accounts, but Kinesis must be a client for the
+	// stream's account and region, such as one made by Clients.
+	Stream string
+
+	// FanOut is used to subscribe to shards when Options.ConsumerName is set. If it is nil, one is
+	// made from Kinesis.
+	FanOut k.FanOut
+
+	records     chan k.Record
+	shards      chan k.ShardStream
+	revoked     chan k.Revocation
+	stopped     chan *ShardWorker
+	workers     map[string]*ShardWorker
+	shardsEnded map[string]bool
+	cancel      context.CancelFunc
+	discovered  chan Unit
+	errors      chan k.Error
+	fatal       chan k.Error
+	rand        *rand.Rand
+	consumerARN string
+
+	// leaseEpoch is incremented each time a worker is started, to identify its lease.
+	leaseEpoch int64
+
+	// seeks carries Seek requests to the goroutine discovering shards, and seekPositions holds the
+	// positions that shards are to be read from when their workers are restarted.
+	seeks         chan seekRequest
+	seekPositions map[string]k.StartingPosition
+
+	// parent is the MultiKinesumer that the Kinesumer reads one of the streams of, if any. It
+	// shares the parent's checkpointer, provisioner, channels and worker limit.
+	parent *MultiKinesumer
+}
+
+type seekRequest struct {
+	shardID  string
+	position k.StartingPosition
+	done     chan error
 }
 
 type Options struct {
@@ -46,17 +105,74 @@ type Options struct {
 	// DefaultGetRecordsThrottle.
 	GetRecordsThrottle time.Duration
 
+	// Throttle paces the GetRecords calls on each shard. The zero value is an AdaptiveThrottle
+	// that waits GetRecordsThrottle between calls.
+	Throttle k.Throttle
+
 	// Amount of time to poll of records if consumer lag is minimal
-	PollTime            int
-	MaxShardWorkers     int
-	ErrHandler          func(k.Error)
+	PollTime        int
+	MaxShardWorkers int
+
+	// ErrHandler is passed errors and informational messages. The zero value logs them to Logger.
+	ErrHandler func(k.Error)
+
+	// Logger is what the default ErrHandler logs to, with fields naming the stream, shard,
+	// sequence number and lock that each message is about. The zero value is DefaultLogger.
+	Logger k.Logger
+
 	DefaultIteratorType string
 
 	// How long to try and get shard iterator
 	ShardAcquisitionTimeout time.Duration
 
+	// How often to try to acquire shards that no worker is reading, such as those of a consumer
+	// that died, after Begin returns. The zero value is half of the provisioner's TTL, so that an
+	// expired lock is taken over within about one TTL.
+	ShardAcquisitionPeriod time.Duration
+
 	// ShardIteratorTimestamp is used when DefaultIteratorType is "AT_TIMESTAMP"
 	ShardIteratorTimestamp time.Time
+
+	// How often the stream is described to pick up new shards after it is resharded. The zero
+	// value is DefaultShardDiscoveryPeriod.
+	ShardDiscoveryPeriod time.Duration
+
+	// If Handler is set, records are passed to it a batch at a time instead of being sent on
+	// Records, and each batch is checkpointed once it has been handled.
+	Handler k.Handler
+
+	// How many times a batch is retried after the Handler fails before the shard worker stops.
+	// Zero doesn't retry and a negative value retries forever. DefaultOptions uses
+	// DefaultHandlerRetries.
+	HandlerRetries int
+
+	// How long to wait before retrying a failed batch. The zero value is
+	// DefaultHandlerRetryBackoff.
+	HandlerRetryBackoff time.Duration
+
+	// If DeadLetter is set, a batch that the Handler still fails after HandlerRetries is passed
+	// to it a record at a time, and the records that keep failing are parked in DeadLetter and
+	// checkpointed, instead of the shard worker stopping.
+	DeadLetter k.DeadLetter
+
+	// If ConsumerName is set, the stream is read with enhanced fan-out: a consumer is registered
+	// on the stream under this name, and records are pushed to it over subscriptions to each
+	// shard instead of being polled with GetRecords.
+	ConsumerName string
+
+	// If ShardStreams is set, each shard's records are sent on its own ShardStream, delivered on
+	// Shards as the shard is acquired, instead of on Records. A slow shard then only holds up its
+	// own worker.
+	ShardStreams bool
+
+	// If DrainTimeout is set, End drains each shard before releasing it: it stops reading, waits
+	// up to DrainTimeout for the records that were handed out to be marked done, and saves the
+	// checkpoints. Records must keep being processed until End returns.
+	DrainTimeout time.Duration
+
+	// Metrics is told about the records read from each shard, requests to Kinesis and the
+	// provisioner, and the number of shards being worked on. The zero value discards them.
+	Metrics k.Metrics
 }
 
 var DefaultOptions = Options{
@@ -67,14 +183,22 @@ var DefaultOptions = Options{
 	GetRecordsThrottle:      DefaultGetRecordsThrottle,
 	PollTime:                2000,
 	MaxShardWorkers:         50,
-	ErrHandler:              DefaultErrHandler,
 	DefaultIteratorType:     "LATEST",
 	ShardAcquisitionTimeout: 90 * time.Second,
+	ShardDiscoveryPeriod:    DefaultShardDiscoveryPeriod,
+	HandlerRetries:          DefaultHandlerRetries,
+	HandlerRetryBackoff:     DefaultHandlerRetryBackoff,
 }
 
+// NewDefault returns a Kinesumer for stream, a name or ARN, with a client from the default session
+// that is in the ARN's region if stream is one.
 func NewDefault(stream string, duration time.Duration) (*Kinesumer, error) {
+	client, err := new(Clients).Kinesis(stream)
+	if err != nil {
+		return nil, err
+	}
 	return New(
-		kinesis.New(session.New()),
+		client,
 		nil,
 		nil,
 		nil,
@@ -106,14 +230,59 @@ func New(kinesis k.Kinesis, checkpointer k.Checkpointer, provisioner k.Provision
 	if len(stream) == 0 {
 		return nil, NewError(ECrit, "Stream name can't be empty", nil)
 	}
+	if IsStreamARN(stream) {
+		if _, err := ParseStreamARN(stream); err != nil {
+			return nil, NewError(ECrit, "Invalid stream ARN", err).With("stream", stream)
+		}
+	}
+
+	opt = withDefaults(opt, duration)
 
+	return &Kinesumer{
+		Kinesis:       kinesis,
+		Checkpointer:  checkpointer,
+		Provisioner:   provisioner,
+		Stream:        stream,
+		Options:       opt,
+		records:       make(chan k.Record, opt.GetRecordsLimit*2+10),
+		shards:        make(chan k.ShardStream),
+		revoked:       make(chan k.Revocation, errorsBuffer),
+		workers:       make(map[string]*ShardWorker),
+		shardsEnded:   make(map[string]bool),
+		seeks:         make(chan seekRequest),
+		seekPositions: make(map[string]k.StartingPosition),
+		errors:        make(chan k.Error, errorsBuffer),
+		rand:          rand.New(randSource),
+	}, nil
+}
+
+// withDefaults fills in the options whose zero values stand for a default, or returns a copy of
+// DefaultOptions if opt is nil.
+// If duration isn't zero, shards without checkpoints are read from that long ago.
+func withDefaults(opt *Options, duration time.Duration) *Options {
 	if opt == nil {
 		tmp := DefaultOptions
 		opt = &tmp
 	}
 
+	if opt.Logger == nil {
+		opt.Logger = DefaultLogger
+	}
+
 	if opt.ErrHandler == nil {
-		opt.ErrHandler = DefaultErrHandler
+		opt.ErrHandler = LogErrHandler(opt.Logger)
+	}
+
+	if opt.HandlerRetryBackoff == 0 {
+		opt.HandlerRetryBackoff = DefaultHandlerRetryBackoff
+	}
+
+	if opt.Metrics == nil {
+		opt.Metrics = emptymetrics.Metrics{}
+	}
+
+	if opt.Throttle == nil {
+		opt.Throttle = NewAdaptiveThrottle(&ThrottleOptions{Interval: opt.GetRecordsThrottle})
 	}
 
 	if duration != 0 {
@@ -121,111 +290,354 @@ func New(kinesis k.Kinesis, checkpointer k.Checkpointer, provisioner k.Provision
 		opt.ShardIteratorTimestamp = time.Now().Add(duration * -1)
 	}
 
-	return &Kinesumer{
-		Kinesis:      kinesis,
-		Checkpointer: checkpointer,
-		Provisioner:  provisioner,
-		Stream:       stream,
-		Options:      opt,
-		records:      make(chan k.Record, opt.GetRecordsLimit*2+10),
-		rand:         rand.New(randSource),
-	}, nil
+	return opt
 }
 
-func (kin *Kinesumer) GetStreams() (streams []string, err error) {
-	streams = make([]string, 0)
-	err = kin.Kinesis.ListStreamsPages(&kinesis.ListStreamsInput{
-		Limit: &kin.Options.ListStreamsLimit,
-	}, func(sts *kinesis.ListStreamsOutput, _ bool) bool {
-		streams = append(streams, aws.StringValueSlice(sts.StreamNames)...)
-		return true
-	})
-	return
+func (kin *Kinesumer) GetStreams(ctx context.Context) (streams []string, err error) {
+	return listStreams(ctx, kin.Kinesis, kin.Options.ListStreamsLimit)
 }
 
-func (kin *Kinesumer) StreamExists() (found bool, err error) {
-	streams, err := kin.GetStreams()
+func (kin *Kinesumer) StreamExists(ctx context.Context) (found bool, err error) {
+	streams, err := kin.GetStreams(ctx)
 	if err != nil {
 		return
 	}
 	for _, stream := range streams {
-		if stream == kin.Stream {
+		if stream == streamName(kin.Stream) {
 			return true, nil
 		}
 	}
 	return
 }
 
-func (kin *Kinesumer) GetShards() (shards []*kinesis.Shard, err error) {
+func (kin *Kinesumer) GetShards(ctx context.Context) (shards []types.Shard, err error) {
+	name, arn := streamParams(kin.Stream)
 	for {
-		retry := false
-		shards = make([]*kinesis.Shard, 0)
-		err = kin.Kinesis.DescribeStreamPages(&kinesis.DescribeStreamInput{
-			Limit:      &kin.Options.DescribeStreamLimit,
-			StreamName: &kin.Stream,
-		}, func(desc *kinesis.DescribeStreamOutput, _ bool) bool {
+		shards = make([]types.Shard, 0)
+		input := &kinesis.DescribeStreamInput{
+			Limit:      aws.Int32(int32(kin.Options.DescribeStreamLimit)),
+			StreamARN:  arn,
+			StreamName: name,
+		}
+		status := types.StreamStatusActive
+		for {
+			desc, err := kin.Kinesis.DescribeStream(ctx, input)
+			if err != nil {
+				return nil, err
+			}
 			if desc == nil || desc.StreamDescription == nil {
-				err = errors.New("Stream could not be described")
-				return false
+				return nil, errors.New("Stream could not be described")
 			}
-			switch aws.StringValue(desc.StreamDescription.StreamStatus) {
-			case "CREATING":
-				retry = true
-				return false
-			case "DELETING":
-				err = errors.New("Stream is being deleted")
-				return false
+			status = desc.StreamDescription.StreamStatus
+			if status == types.StreamStatusCreating || status == types.StreamStatusDeleting {
+				break
 			}
 			shards = append(shards, desc.StreamDescription.Shards...)
-			return true
-		})
-		if retry {
-			time.Sleep(time.Second)
-		} else {
-			return
+			if !aws.ToBool(desc.StreamDescription.HasMoreShards) || len(desc.StreamDescription.Shards) == 0 {
+				break
+			}
+			input.ExclusiveStartShardId = shards[len(shards)-1].ShardId
+		}
+
+		switch status {
+		case types.StreamStatusCreating:
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		case types.StreamStatusDeleting:
+			return nil, errStreamDeleting
+		default:
+			return shards, nil
 		}
 	}
 }
 
-func (kin *Kinesumer) LaunchShardWorker(shards []*kinesis.Shard) (int, *ShardWorker, error) {
+func (kin *Kinesumer) LaunchShardWorker(ctx context.Context, shards []types.Shard) (int, *ShardWorker, error) {
+	if !kin.reserveWorker() {
+		return 0, nil, errWorkerLimit
+	}
+
 	perm := kin.rand.Perm(len(shards))
 	for _, j := range perm {
-		err := kin.Provisioner.TryAcquire(aws.StringValue(shards[j].ShardId))
+		key := kin.shardKey(aws.ToString(shards[j].ShardId))
+		err := kin.Provisioner.TryAcquire(ctx, key)
+		kin.Options.Metrics.LockAcquired(key, err)
 		if err == nil {
-			worker := &ShardWorker{
-				kinesis:                kin.Kinesis,
-				shard:                  shards[j],
-				checkpointer:           kin.Checkpointer,
-				stream:                 kin.Stream,
-				pollTime:               kin.Options.PollTime,
-				stop:                   kin.stop,
-				stopped:                kin.stopped,
-				c:                      kin.records,
-				provisioner:            kin.Provisioner,
-				errHandler:             kin.Options.ErrHandler,
-				defaultIteratorType:    kin.Options.DefaultIteratorType,
-				shardIteratorTimestamp: kin.Options.ShardIteratorTimestamp,
-				getRecordsThrottle:     getRecordsThrottle(kin.Options.GetRecordsThrottle),
-				GetRecordsLimit:        kin.Options.GetRecordsLimit,
-			}
-			kin.nRunning++
-			go worker.RunWorker()
-			return j, worker, nil
+			return j, kin.startWorker(ctx, shards[j]), nil
 		}
 	}
+	kin.releaseWorker()
 	return 0, nil, errors.New("No unlocked keys")
 }
 
+// shardKey returns the key that a shard is locked and checkpointed under: its ID, or the ShardKey
+// of the stream and shard if the Kinesumer reads one of the streams of a MultiKinesumer.
+func (kin *Kinesumer) shardKey(shardID string) string {
+	if kin.parent == nil {
+		return shardID
+	}
+	return k.ShardKey(kin.Stream, shardID)
+}
+
+// reserveWorker reserves a place for a worker under the MaxShardWorkers of the MultiKinesumer that
+// the Kinesumer belongs to, returning false if there is none. A Kinesumer on its own is only
+// limited by acquireShards.
+func (kin *Kinesumer) reserveWorker() bool {
+	return kin.parent == nil || kin.parent.reserveWorker()
+}
+
+// releaseWorker gives up a place reserved by reserveWorker.
+func (kin *Kinesumer) releaseWorker() {
+	if kin.parent != nil {
+		kin.parent.releaseWorker()
+	}
+}
+
+// shardsOwned tells the metrics how many shards are being worked on after a worker has started or
+// stopped, counting those of every stream of a MultiKinesumer.
+func (kin *Kinesumer) shardsOwned(delta int) {
+	n := len(kin.workers)
+	if kin.parent != nil {
+		n = kin.parent.shardsOwned(delta)
+	}
+	kin.Options.Metrics.ShardsOwned(n)
+}
+
+// startWorker starts a worker on a shard whose lock has been acquired.
+func (kin *Kinesumer) startWorker(ctx context.Context, shard types.Shard) *ShardWorker {
+	worker := &ShardWorker{
+		kinesis:                kin.Kinesis,
+		shard:                  shard,
+		checkpointer:           kin.Checkpointer,
+		stream:                 kin.Stream,
+		pollTime:               kin.Options.PollTime,
+		c:                      kin.records,
+		provisioner:            kin.Provisioner,
+		errHandler:             kin.Options.ErrHandler,
+		defaultIteratorType:    kin.Options.DefaultIteratorType,
+		shardIteratorTimestamp: kin.Options.ShardIteratorTimestamp,
+		throttle:               kin.Options.Throttle,
+		GetRecordsLimit:        kin.Options.GetRecordsLimit,
+		handler:                kin.Options.Handler,
+		handlerRetries:         kin.Options.HandlerRetries,
+		handlerRetryBackoff:    kin.Options.HandlerRetryBackoff,
+		deadLetter:             kin.Options.DeadLetter,
+		shed:                   make(chan Unit),
+		seek:                   kin.takeSeek(aws.ToString(shard.ShardId)),
+		drainTimeout:           kin.Options.DrainTimeout,
+		metrics:                kin.Options.Metrics,
+	}
+	if kin.parent != nil {
+		worker.key = kin.shardKey(aws.ToString(shard.ShardId))
+	}
+	kin.leaseEpoch++
+	worker.leaseEpoch = kin.leaseEpoch
+	if len(kin.consumerARN) > 0 {
+		worker.fanOut = kin.FanOut
+		worker.consumerARN = kin.consumerARN
+	}
+
+	var stream *shardStream
+	if kin.Options.ShardStreams {
+		stream = &shardStream{
+			streamName: kin.Stream,
+			shardID:    aws.ToString(shard.ShardId),
+			c:          make(chan k.Record, kin.Options.GetRecordsLimit*2+10),
+		}
+		worker.c = stream.c
+	}
+
+	ctx, worker.cancel = context.WithCancel(ctx)
+	kin.workers[aws.ToString(shard.ShardId)] = worker
+	kin.shardsOwned(1)
+	go func() {
+		if stream != nil {
+			select {
+			case kin.shards <- stream:
+			case <-ctx.Done():
+			}
+		}
+
+		err := worker.RunWorker(ctx)
+		if err != nil && ctx.Err() == nil {
+			shardID := aws.ToString(worker.shard.ShardId)
+			kin.report(kin.newError(EError, "Shard worker for "+shardID+" stopped", err).With("shard", shardID))
+		}
+		if worker.revoked {
+			kin.revoke(worker)
+		}
+		if stream != nil {
+			stream.close(worker, err)
+		}
+		kin.stopped <- worker
+	}()
+	return worker
+}
+
+// newError returns an error with fields naming the stream and, if the provisioner has one, the ID
+// that its locks are held under.
+func (kin *Kinesumer) newError(severity, message string, origin error) *Error {
+	err := NewError(severity, message, origin).With("stream", kin.Stream)
+	if owner, ok := kin.Provisioner.(k.Owner); ok {
+		err.With("lock", owner.Owner())
+	}
+	return err
+}
+
+// revoke fences off the records of a worker whose lease was lost, so that they aren't
+// checkpointed, and tells the consumer on Revoked.
+func (kin *Kinesumer) revoke(worker *ShardWorker) {
+	shardID := aws.ToString(worker.shard.ShardId)
+	if fencer, ok := kin.Checkpointer.(k.Fencer); ok {
+		fencer.Revoke(kin.shardKey(shardID), worker.leaseEpoch)
+	}
+
+	select {
+	case kin.revoked <- k.Revocation{StreamName: kin.Stream, ShardID: shardID, LeaseEpoch: worker.leaseEpoch}:
+	default:
+	}
+}
+
+// report passes err to the ErrHandler and sends it to the Errors channel. Run returns the first
+// critical error that is reported.
+func (kin *Kinesumer) report(err k.Error) {
+	kin.Options.ErrHandler(err)
+
+	select {
+	case kin.errors <- err:
+	default:
+	}
+
+	if err.Severity() == ECrit && kin.fatal != nil {
+		select {
+		case kin.fatal <- err:
+		default:
+		}
+	}
+}
+
+// Errors returns a channel of the errors encountered while consuming the stream. When a shard
+// worker fails its error is sent on this channel and its shard is released, to be acquired again
+// by the next round of shard discovery. Errors are dropped if the channel isn't being read and
+// its buffer is full.
+func (kin *Kinesumer) Errors() <-chan k.Error {
+	return kin.errors
+}
+
+// startableShards filters shards down to those that a worker may be started on: shards that
+// aren't already being worked on by this Kinesumer, that haven't been read to their end, and
+// whose parents have been read to their end or have expired from the stream.
+func (kin *Kinesumer) startableShards(ctx context.Context, shards []types.Shard) []types.Shard {
+	listed := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		listed[aws.ToString(shard.ShardId)] = true
+	}
+
+	for shardID := range kin.shardsEnded {
+		if !listed[shardID] {
+			delete(kin.shardsEnded, shardID)
+		}
+	}
+
+	// A shard whose checkpoint can't be read isn't known to have ended, so its children wait for
+	// the next discovery.
+	ended := func(shardID string) bool {
+		if kin.shardsEnded[shardID] {
+			return true
+		}
+		sequence, err := kin.Checkpointer.GetStartSequence(ctx, kin.shardKey(shardID))
+		if err != nil {
+			kin.Options.ErrHandler(kin.newError(EWarn, "Could not get checkpoint", err).With("shard", shardID))
+			return false
+		}
+		if sequence == k.ShardEnd {
+			kin.shardsEnded[shardID] = true
+		}
+		return kin.shardsEnded[shardID]
+	}
+
+	parentEnded := func(parent *string) bool {
+		shardID := aws.ToString(parent)
+		return len(shardID) == 0 || !listed[shardID] || ended(shardID)
+	}
+
+	startable := make([]types.Shard, 0)
+	for _, shard := range shards {
+		shardID := aws.ToString(shard.ShardId)
+		if kin.workers[shardID] != nil || ended(shardID) {
+			continue
+		}
+		if parentEnded(shard.ParentShardId) && parentEnded(shard.AdjacentParentShardId) {
+			startable = append(startable, shard)
+		}
+	}
+	return startable
+}
+
+// Run consumes the stream until ctx is done or a critical error occurs, such as the stream being
+// deleted, and then stops all of the workers. It returns the critical error, or nil if ctx is
+// done. Errors that only stop a single shard worker are sent on the Errors channel instead.
+func (kin *Kinesumer) Run(ctx context.Context) error {
+	kin.fatal = make(chan k.Error, 1)
+
+	if _, err := kin.begin(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-kin.fatal:
+	}
+
+	kin.End()
+	return err
+}
+
 func (kin *Kinesumer) Begin() (int, error) {
-	shards, err := kin.GetShards()
+	return kin.BeginContext(context.Background())
+}
+
+// BeginContext starts consuming the stream and returns the number of shard workers that were
+// started. The workers run until End is called or ctx is done.
+func (kin *Kinesumer) BeginContext(ctx context.Context) (int, error) {
+	n, err := kin.begin(ctx)
 	if err != nil {
-		return 0, err
+		return n, err
 	}
 
-	err = kin.Checkpointer.Begin()
+	if n < 1 {
+		return n, NewError(EWarn, "0 shard workers started", nil)
+	}
+
+	return n, nil
+}
+
+func (kin *Kinesumer) begin(ctx context.Context) (int, error) {
+	shards, err := kin.GetShards(ctx)
 	if err != nil {
 		return 0, err
 	}
+	listed := shards
+
+	if len(kin.Options.ConsumerName) > 0 {
+		if err := kin.registerConsumer(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	// The checkpointer of a MultiKinesumer is begun once for all of its streams.
+	if kin.parent == nil {
+		if err := kin.Checkpointer.Begin(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	ctx, kin.cancel = context.WithCancel(ctx)
+	kin.stopped = make(chan *ShardWorker)
+	kin.discovered = make(chan Unit)
 
 	n := kin.Options.MaxShardWorkers
 	if n <= 0 || len(shards) < n {
@@ -239,53 +651,327 @@ func (kin *Kinesumer) Begin() (int, error) {
 
 	start := time.Now()
 
-	kin.stop = make(chan Unit, n)
-	kin.stopped = make(chan Unit, n)
+	shards = kin.startableShards(ctx, shards)
+	if len(shards) < n {
+		n = len(shards)
+	}
+	if share, ok := kin.fairShare(ctx, len(shards)); ok && share < n {
+		n = share
+	}
 
 	workers := make([]*ShardWorker, 0)
-	for kin.nRunning < n && len(shards) > 0 && time.Now().Sub(start) < tryTime {
-		for i := kin.nRunning; i < n; i++ {
-			j, worker, err := kin.LaunchShardWorker(shards)
+	for ctx.Err() == nil && len(kin.workers) < n && len(shards) > 0 && time.Now().Sub(start) < tryTime {
+		for i := len(kin.workers); i < n; i++ {
+			j, worker, err := kin.LaunchShardWorker(ctx, shards)
+			if err == errWorkerLimit {
+				n = len(kin.workers)
+				break
+			}
 			if err != nil {
-				kin.Options.ErrHandler(NewError(EWarn, "Could not start shard worker", err))
+				kin.Options.ErrHandler(kin.newError(EWarn, "Could not start shard worker", err))
 			} else {
 				workers = append(workers, worker)
 				shards = append(shards[:j], shards[j+1:]...)
 			}
 		}
-		time.Sleep(time.Duration(500+rand.Intn(1500)) * time.Millisecond)
+
+		select {
+		case <-time.After(time.Duration(500+rand.Intn(1500)) * time.Millisecond):
+		case <-ctx.Done():
+		}
 	}
 
-	kin.Options.ErrHandler(NewError(EInfo, fmt.Sprintf("%v/%v workers started", kin.nRunning, n), nil))
+	kin.Options.ErrHandler(kin.newError(EInfo, fmt.Sprintf("%v/%v workers started", len(kin.workers), n), nil))
 
-	if len(workers) < 1 {
-		return len(workers), NewError(EWarn, "0 shard workers started", nil)
-	}
+	go kin.discoverShards(ctx, listed)
 
 	return len(workers), nil
 }
 
-func (kin *Kinesumer) End() {
-	for kin.nRunning > 0 {
+// registerConsumer registers Options.ConsumerName as an enhanced fan-out consumer of the stream.
+func (kin *Kinesumer) registerConsumer(ctx context.Context) error {
+	if kin.FanOut == nil {
+		client, ok := kin.Kinesis.(fanout.API)
+		if !ok {
+			return NewError(ECrit, "FanOut must be set to use enhanced fan-out", nil)
+		}
+		kin.FanOut = fanout.New(client)
+	}
+
+	arn, err := kin.FanOut.RegisterStreamConsumer(ctx, kin.Stream, kin.Options.ConsumerName)
+	if err != nil {
+		return NewError(ECrit, "Could not register stream consumer", err)
+	}
+	kin.consumerARN = arn
+	return nil
+}
+
+// discoverShards periodically describes the stream and starts workers on shards that have become
+// startable, such as the children of a shard that was split or merged. In between, it keeps trying
+// to acquire the listed shards that no worker is reading, so that the shards of a consumer that
+// died are taken over once their locks expire. It also keeps track of workers that stop on their
+// own.
+func (kin *Kinesumer) discoverShards(ctx context.Context, shards []types.Shard) {
+	defer close(kin.discovered)
+
+	period := kin.Options.ShardDiscoveryPeriod
+	if period == 0 {
+		period = DefaultShardDiscoveryPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	acquisitionPeriod := kin.Options.ShardAcquisitionPeriod
+	if acquisitionPeriod == 0 {
+		acquisitionPeriod = kin.Provisioner.TTL() / 2
+	}
+	if acquisitionPeriod <= 0 {
+		acquisitionPeriod = period
+	}
+	acquisitionTicker := time.NewTicker(acquisitionPeriod)
+	defer acquisitionTicker.Stop()
+
+	for {
 		select {
-		case <-kin.stopped:
-			kin.nRunning--
-		case kin.stop <- Unit{}:
+		case <-ctx.Done():
+			return
+		case worker := <-kin.stopped:
+			kin.workerStopped(worker)
+			shardID := aws.ToString(worker.shard.ShardId)
+			if _, ok := kin.seekPositions[shardID]; ok {
+				// A seeked shard that isn't started again straight away, because it has ended or
+				// its lock was taken by another consumer, isn't read from the position later.
+				kin.acquireShards(ctx, shards)
+				delete(kin.seekPositions, shardID)
+			}
+		case req := <-kin.seeks:
+			req.done <- kin.seek(req.shardID, req.position, shards)
+		case <-acquisitionTicker.C:
+			kin.acquireShards(ctx, shards)
+		case <-ticker.C:
+			listed, err := kin.GetShards(ctx)
+			if err != nil {
+				severity := EWarn
+				if errorCode(err) == "ResourceNotFoundException" || err == errStreamDeleting {
+					severity = ECrit
+				}
+				kin.report(kin.newError(severity, "Could not describe stream", err))
+				continue
+			}
+			shards = listed
+			kin.acquireShards(ctx, shards)
+		}
+	}
+}
+
+// seek sets where a shard, or every shard being worked on if shardID is empty, is to be read from,
+// and stops the workers on them so that they are started again from there.
+func (kin *Kinesumer) seek(shardID string, position k.StartingPosition, shards []types.Shard) error {
+	found := false
+	for _, shard := range shards {
+		id := aws.ToString(shard.ShardId)
+		if len(shardID) > 0 && id != shardID {
+			continue
+		}
+		found = true
+		if worker := kin.workers[id]; worker != nil {
+			kin.seekPositions[id] = position
+			worker.cancel()
+		} else if len(shardID) > 0 {
+			return NewError(EWarn, "Shard "+shardID+" isn't being worked on by this Kinesumer", nil)
+		}
+	}
+	if !found {
+		return NewError(EWarn, "Unknown shard "+shardID, nil)
+	}
+	return nil
+}
+
+// takeSeek returns where a worker starting on a shard should read it from instead of its
+// checkpoint, if the shard has been seeked.
+func (kin *Kinesumer) takeSeek(shardID string) *k.StartingPosition {
+	position, ok := kin.seekPositions[shardID]
+	if !ok {
+		return nil
+	}
+	delete(kin.seekPositions, shardID)
+	return &position
+}
+
+// acquireShards starts workers on the startable shards whose locks can be acquired, up to
+// MaxShardWorkers or this Kinesumer's fair share of them, and rebalances the shards between
+// consumers.
+func (kin *Kinesumer) acquireShards(ctx context.Context, shards []types.Shard) {
+	shards = kin.startableShards(ctx, shards)
+	max := kin.Options.MaxShardWorkers
+	share, balanced := kin.fairShare(ctx, len(kin.workers)+len(shards))
+	if balanced && (max <= 0 || share < max) {
+		max = share
+	}
+
+	for len(shards) > 0 && (max <= 0 || kin.activeWorkers() < max) {
+		j, _, err := kin.LaunchShardWorker(ctx, shards)
+		if err != nil {
+			break
+		}
+		shards = append(shards[:j], shards[j+1:]...)
+	}
+
+	if balanced {
+		kin.rebalance(ctx, share, shards)
+	}
+}
+
+// fairShare returns how many of the shards this Kinesumer should work on for them to be spread
+// evenly across the consumers of the stream. ok is false if the provisioner can't count the
+// consumers.
+func (kin *Kinesumer) fairShare(ctx context.Context, shards int) (share int, ok bool) {
+	balancer, ok := kin.Provisioner.(k.Balancer)
+	if !ok {
+		return 0, false
+	}
+
+	// Stay counted until a couple of rounds of shard discovery have been missed.
+	period := kin.Options.ShardDiscoveryPeriod
+	if period == 0 {
+		period = DefaultShardDiscoveryPeriod
+	}
+	consumers, err := balancer.Consumers(ctx, 2*period+kin.Provisioner.TTL())
+	if err != nil {
+		kin.Options.ErrHandler(kin.newError(EWarn, "Could not count consumers", err))
+		return 0, false
+	}
+	if consumers < 1 {
+		consumers = 1
+	}
+	return (shards + consumers - 1) / consumers, true
+}
+
+// rebalance hands shards off to other consumers while this Kinesumer has more than its share of
+// them. If it has none and the provisioner can steal, it steals one of shards instead, so that
+// the other consumers count it and hand shards off to it.
+func (kin *Kinesumer) rebalance(ctx context.Context, share int, shards []types.Shard) {
+	active := kin.activeWorkers()
+	for shardID, worker := range kin.workers {
+		if active <= share {
+			break
+		}
+		if !worker.shedding() {
+			kin.Options.ErrHandler(kin.newError(EInfo, "Handing off shard "+shardID, nil).With("shard", shardID))
+			worker.Shed()
+			active--
+		}
+	}
+
+	stealer, ok := kin.Provisioner.(k.Stealer)
+	if !ok || active > 0 || share == 0 || len(shards) == 0 {
+		return
+	}
+	if !kin.reserveWorker() {
+		return
+	}
+	shard := shards[kin.rand.Intn(len(shards))]
+	key := kin.shardKey(aws.ToString(shard.ShardId))
+	err := stealer.Steal(ctx, key)
+	kin.Options.Metrics.LockAcquired(key, err)
+	if err != nil {
+		kin.releaseWorker()
+		kin.Options.ErrHandler(kin.newError(EWarn, "Could not steal shard", err).With("shard", aws.ToString(shard.ShardId)))
+		return
+	}
+	kin.startWorker(ctx, shard)
+}
+
+// activeWorkers returns the number of workers that aren't handing their shards off.
+func (kin *Kinesumer) activeWorkers() int {
+	n := 0
+	for _, worker := range kin.workers {
+		if !worker.shedding() {
+			n++
 		}
 	}
-	kin.Checkpointer.End()
+	return n
+}
+
+func (kin *Kinesumer) workerStopped(worker *ShardWorker) {
+	shardID := aws.ToString(worker.shard.ShardId)
+	delete(kin.workers, shardID)
+	kin.releaseWorker()
+	kin.shardsOwned(-1)
+	if worker.ended {
+		kin.shardsEnded[shardID] = true
+	}
+}
+
+// End stops the shard workers, waits for them to exit and then ends the checkpointer. If
+// Options.DrainTimeout is set, each worker waits for its records to be marked done and saves the
+// checkpoints before releasing its shard. Calling End again, such as after Run has returned, does
+// nothing.
+func (kin *Kinesumer) End() {
+	if kin.cancel == nil {
+		return
+	}
+	kin.cancel()
+	kin.cancel = nil
+	<-kin.discovered
+	for len(kin.workers) > 0 {
+		kin.workerStopped(<-kin.stopped)
+	}
+	if kin.parent == nil {
+		close(kin.shards)
+		kin.Checkpointer.End()
+	}
 }
 
 func (kin *Kinesumer) Records() <-chan k.Record {
 	return kin.records
 }
 
-// getRecordsThrottle returns a channel that will tick every time d has elapsed.
-// If d is 0, DefaultGetRecordsThrottle will be used.
-func getRecordsThrottle(d time.Duration) <-chan time.Time {
-	if d == 0 {
-		d = DefaultGetRecordsThrottle
+// Seek makes the shard with shardID, or every shard of the stream if shardID is empty, be read
+// from position instead of its checkpoint, which is overwritten as the records from there are
+// processed. The workers on the shards are restarted from position. Only the shards that this
+// Kinesumer is working on are seeked: seeking another shard is an error, and a shard that isn't
+// started again straight away, because it has been read to its end or its lock was taken by
+// another consumer, carries on from its checkpoint when it is next acquired. Seek can only be
+// called after Begin.
+func (kin *Kinesumer) Seek(ctx context.Context, shardID string, position k.StartingPosition) error {
+	switch position.Type {
+	case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+		if len(position.SequenceNumber) == 0 {
+			return NewError(EWarn, position.Type+" needs a sequence number", nil)
+		}
+	case "AT_TIMESTAMP":
+		if position.Timestamp.IsZero() {
+			return NewError(EWarn, "AT_TIMESTAMP needs a timestamp", nil)
+		}
+	case "TRIM_HORIZON", "LATEST":
+	default:
+		return NewError(EWarn, "Unknown shard iterator type "+position.Type, nil)
 	}
+	if kin.discovered == nil {
+		return NewError(EWarn, "Seek can only be called after Begin", nil)
+	}
+
+	req := seekRequest{shardID: shardID, position: position, done: make(chan error, 1)}
+	select {
+	case kin.seeks <- req:
+	case <-kin.discovered:
+		return NewError(EWarn, "Kinesumer has ended", nil)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-req.done
+}
+
+// Revoked returns a channel on which a Revocation is sent when the lease on a shard is lost, so
+// that the processing of its records can be abandoned. Revocations are dropped if the channel
+// isn't being read and its buffer is full.
+func (kin *Kinesumer) Revoked() <-chan k.Revocation {
+	return kin.revoked
+}
 
-	return time.NewTicker(d).C
+// Shards returns a channel on which each shard that is acquired is delivered as its own
+// ShardStream, if Options.ShardStreams is set. It is closed by End.
+func (kin *Kinesumer) Shards() <-chan k.ShardStream {
+	return kin.shards
 }
@@ -1,13 +1,16 @@
 package kinesumer
 
 import (
+	"context"
+	"errors"
 	"math/rand"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	k "github.com/remind101/kinesumer/interface"
 	"github.com/remind101/kinesumer/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -33,12 +36,31 @@ func makeTestKinesumer(t *testing.T) (*Kinesumer, *mocks.Kinesis, *mocks.Checkpo
 	return k, kin, sssm, prov
 }
 
+func TestNewDefaults(t *testing.T) {
+	k, err := New(new(mocks.Kinesis), nil, nil, nil, "TestStream", &Options{HandlerRetries: -1}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, k.Options.HandlerRetries)
+	assert.Equal(t, DefaultHandlerRetryBackoff, k.Options.HandlerRetryBackoff)
+
+	// Zero values are defaulted field by field, not only when no options are given.
+	k, err = New(new(mocks.Kinesis), nil, nil, nil, "TestStream", &Options{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultHandlerRetryBackoff, k.Options.HandlerRetryBackoff)
+
+	// Zero retries means that a failed batch isn't retried, as it does for Pools and Producers.
+	assert.Equal(t, 0, k.Options.HandlerRetries)
+
+	k, err = New(new(mocks.Kinesis), nil, nil, nil, "TestStream", nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultHandlerRetries, k.Options.HandlerRetries)
+}
+
 func TestKinesumerGetStreams(t *testing.T) {
 	k, kin, _, _ := makeTestKinesumer(t)
-	kin.On("ListStreamsPages", mock.Anything, mock.Anything).Return(nil)
-	streams, err := k.GetStreams()
+	kin.On("ListStreams", mock.Anything, mock.Anything).Return(nil)
+	streams, err := k.GetStreams(context.Background())
 	assert.Nil(t, err)
-	kin.AssertNumberOfCalls(t, "ListStreamsPages", 1)
+	kin.AssertNumberOfCalls(t, "ListStreams", 2)
 	assert.Equal(t, 3, len(streams))
 	assert.Equal(t, streams[2], "c")
 }
@@ -46,52 +68,566 @@ func TestKinesumerGetStreams(t *testing.T) {
 func TestKinesumerStreamExists(t *testing.T) {
 	k, kin, _, _ := makeTestKinesumer(t)
 	k.Stream = "c"
-	kin.On("ListStreamsPages", mock.Anything, mock.Anything).Return(nil)
-	e, err := k.StreamExists()
+	kin.On("ListStreams", mock.Anything, mock.Anything).Return(nil)
+	e, err := k.StreamExists(context.Background())
 	assert.Nil(t, err)
-	kin.AssertNumberOfCalls(t, "ListStreamsPages", 1)
+	kin.AssertNumberOfCalls(t, "ListStreams", 2)
 	assert.True(t, e)
 }
 
 func TestKinesumerGetShards(t *testing.T) {
 	k, kin, _, _ := makeTestKinesumer(t)
 	k.Stream = "c"
-	kin.On("DescribeStreamPages", mock.Anything, mock.Anything).Return(nil)
-	shards, err := k.GetShards()
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	shards, err := k.GetShards(context.Background())
 	assert.Nil(t, err)
-	kin.AssertNumberOfCalls(t, "DescribeStreamPages", 1)
+	// The shards are described a page at a time.
+	kin.AssertNumberOfCalls(t, "DescribeStream", 2)
 	assert.Equal(t, 2, len(shards))
 	assert.Equal(t, "shard1", *shards[1].ShardId)
 }
 
+func TestKinesumerStreamARN(t *testing.T) {
+	_, err := New(new(mocks.Kinesis), nil, nil, nil, "arn:aws:kinesis:us-west-2:123456789012:table/c", nil, 0)
+	assert.Error(t, err)
+
+	k, kin, _, _ := makeTestKinesumer(t)
+	k.Stream = "arn:aws:kinesis:us-west-2:123456789012:stream/c"
+	kin.On("ListStreams", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+
+	e, err := k.StreamExists(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, e)
+
+	_, err = k.GetShards(context.Background())
+	assert.Nil(t, err)
+	// A stream given by ARN is addressed by it.
+	input := kin.Calls[len(kin.Calls)-1].Arguments.Get(1).(*kinesis.DescribeStreamInput)
+	assert.Equal(t, k.Stream, aws.ToString(input.StreamARN))
+	assert.Nil(t, input.StreamName)
+	assert.Equal(t, "shard0", aws.ToString(input.ExclusiveStartShardId))
+}
+
 func TestKinesumerBeginEnd(t *testing.T) {
 	k, kin, sssm, prov := makeTestKinesumer(t)
 	k.Stream = "c"
 
-	kin.On("DescribeStreamPages", mock.Anything, mock.Anything).Return(awserr.New("bad", "bad", nil)).Once()
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(apiError("bad", "bad")).Once()
 	_, err := k.Begin()
 	assert.Error(t, err)
 
 	prov.On("TTL").Return(time.Millisecond * 10)
-	prov.On("TryAcquire", mock.Anything).Return(nil)
-	prov.On("Heartbeat", mock.Anything).Return(nil)
-	prov.On("Release", mock.Anything).Return(nil)
-	kin.On("DescribeStreamPages", mock.Anything, mock.Anything).Return(awserr.Error(nil))
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
 	sssm.On("Begin", mock.Anything).Return(nil)
-	sssm.On("GetStartSequence", mock.Anything).Return("0").Once()
-	sssm.On("GetStartSequence", mock.Anything).Return("")
-	sssm.On("TryAcquire", mock.Anything).Return(nil)
-	kin.On("GetShardIterator", mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("0", nil).Once()
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
 		ShardIterator: aws.String("0"),
-	}, awserr.Error(nil))
-	kin.On("GetRecords", mock.Anything).Return(&kinesis.GetRecordsOutput{
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
 		MillisBehindLatest: aws.Int64(0),
 		NextShardIterator:  aws.String("AAAAA"),
-		Records:            []*kinesis.Record{},
-	}, awserr.Error(nil))
+		Records:            []types.Record{},
+	}, nil)
 	sssm.On("End").Return()
 	_, err = k.Begin()
 	assert.Nil(t, err)
-	assert.Equal(t, 2, k.nRunning)
+	assert.Equal(t, 2, len(k.workers))
 	k.End()
 }
+
+func TestKinesumerBeginFanOut(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Options.ConsumerName = "app"
+
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	// The Kinesis mock can't be used to make a FanOut.
+	_, err := kinesumer.Begin()
+	assert.Error(t, err)
+	assert.Equal(t, ECrit, err.(*Error).Severity())
+
+	fanOut := new(mocks.FanOut)
+	kinesumer.FanOut = fanOut
+	fanOut.On("RegisterStreamConsumer", mock.Anything, "TestStream", "app").Return("arn:consumer", nil)
+	fanOut.On("SubscribeToShard", mock.Anything, "arn:consumer", mock.Anything, mock.Anything).Return(newTestSubscription(nil), nil)
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+
+	_, err = kinesumer.Begin()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(kinesumer.workers))
+	for _, worker := range kinesumer.workers {
+		assert.Equal(t, fanOut, worker.fanOut)
+		assert.Equal(t, "arn:consumer", worker.consumerARN)
+	}
+	kinesumer.End()
+}
+
+func TestKinesumerStartableShards(t *testing.T) {
+	kin, _, sssm, _ := makeTestKinesumer(t)
+
+	shard := func(id, parent, adjacentParent string) types.Shard {
+		s := types.Shard{ShardId: aws.String(id)}
+		if parent != "" {
+			s.ParentShardId = aws.String(parent)
+		}
+		if adjacentParent != "" {
+			s.AdjacentParentShardId = aws.String(adjacentParent)
+		}
+		return s
+	}
+	shards := []types.Shard{
+		shard("shard0", "", ""),
+		shard("shard1", "", ""),
+		shard("shard2", "shard0", ""),
+		shard("shard3", "shard0", ""),
+		shard("shard4", "shard1", "shard3"),
+		shard("shard5", "expired", ""),
+	}
+
+	sssm.On("GetStartSequence", mock.Anything, "shard0").Return(k.ShardEnd, nil)
+	sssm.On("GetStartSequence", mock.Anything, "shard1").Return("123", nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+
+	kin.workers["shard3"] = &ShardWorker{}
+
+	ids := func(shards []types.Shard) []string {
+		ids := make([]string, len(shards))
+		for i, shard := range shards {
+			ids[i] = aws.ToString(shard.ShardId)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"shard1", "shard2", "shard5"}, ids(kin.startableShards(context.Background(), shards)))
+
+	kin.shardsEnded["shard1"] = true
+	delete(kin.workers, "shard3")
+	kin.shardsEnded["shard3"] = true
+	assert.Equal(t, []string{"shard2", "shard4", "shard5"}, ids(kin.startableShards(context.Background(), shards)))
+}
+
+func TestKinesumerRun(t *testing.T) {
+	k, kin, sssm, prov := makeTestKinesumer(t)
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, k.Run(ctx))
+	assert.Equal(t, 0, len(k.workers))
+	sssm.AssertCalled(t, "End")
+	prov.AssertNumberOfCalls(t, "Release", 2)
+
+	// Run has already ended the Kinesumer, so a deferred End does nothing.
+	k.End()
+	sssm.AssertNumberOfCalls(t, "End", 1)
+}
+
+func TestKinesumerRunWorkerFailure(t *testing.T) {
+	k, kin, sssm, prov := makeTestKinesumer(t)
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(nil, apiError("bad", "bad"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- k.Run(ctx)
+	}()
+
+	err := <-k.Errors()
+	assert.Equal(t, EError, err.Severity())
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, 0, len(k.workers))
+	prov.AssertCalled(t, "Release", mock.Anything, mock.Anything)
+}
+
+func TestKinesumerRunReacquire(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Options.ShardAcquisitionPeriod = time.Millisecond
+
+	reacquired := make(chan Unit, 1)
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, "shard0").Return(nil).Once()
+	prov.On("TryAcquire", mock.Anything, "shard0").Return(nil).Run(func(mock.Arguments) {
+		select {
+		case reacquired <- Unit{}:
+		default:
+		}
+	})
+	prov.On("TryAcquire", mock.Anything, "shard1").Return(nil)
+	// The lock on shard0 is lost, as if it had expired.
+	prov.On("Heartbeat", mock.Anything, "shard0").Return(errors.New("lock lost")).Once()
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	_, err := kinesumer.Begin()
+	assert.Nil(t, err)
+
+	select {
+	case <-reacquired:
+	case <-time.After(time.Second):
+		t.Error("shard0 wasn't reacquired")
+	}
+	kinesumer.End()
+	// The stream was only described by Begin.
+	kin.AssertNumberOfCalls(t, "DescribeStream", 2)
+}
+
+func TestKinesumerShardStreams(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Options.ShardStreams = true
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	// The lock on shard0 is lost as soon as its worker starts.
+	prov.On("Heartbeat", mock.Anything, "shard0").Return(errors.New("lock lost")).Once()
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("Track", mock.Anything).Return()
+	sssm.On("DoneC").Return(make(chan k.Record, 100))
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records: []types.Record{{
+			Data:           []byte("hello"),
+			PartitionKey:   aws.String("aaaa"),
+			SequenceNumber: aws.String("150"),
+		}},
+	}, nil)
+
+	_, err := kinesumer.Begin()
+	assert.Nil(t, err)
+
+	streams := make(map[string]k.ShardStream)
+	for len(streams) < 2 {
+		stream := <-kinesumer.Shards()
+		if streams[stream.ShardID()] == nil {
+			streams[stream.ShardID()] = stream
+		}
+	}
+	assert.Empty(t, kinesumer.Records())
+
+	record := <-streams["shard1"].Records()
+	assert.Equal(t, "shard1", record.ShardId())
+	record.Done()
+
+	for range streams["shard0"].Records() {
+	}
+	assert.False(t, streams["shard0"].Ended())
+	assert.Equal(t, ErrLeaseLost, streams["shard0"].Err())
+
+	go func() {
+		for range kinesumer.Shards() {
+		}
+	}()
+	kinesumer.End()
+	for range streams["shard1"].Records() {
+	}
+	assert.Equal(t, context.Canceled, streams["shard1"].Err())
+}
+
+// fencingCheckpointer is a checkpointer that can revoke shards.
+type fencingCheckpointer struct {
+	*mocks.Checkpointer
+	revoked chan k.Revocation
+}
+
+func (c fencingCheckpointer) Revoke(shardID string, epoch int64) {
+	c.revoked <- k.Revocation{ShardID: shardID, LeaseEpoch: epoch}
+}
+
+func TestKinesumerRevoked(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	fencer := fencingCheckpointer{sssm, make(chan k.Revocation, 10)}
+	kinesumer.Checkpointer = fencer
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	// The lock on shard0 is lost as soon as its worker starts.
+	prov.On("Heartbeat", mock.Anything, "shard0").Return(errors.New("lock lost")).Once()
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	_, err := kinesumer.Begin()
+	assert.Nil(t, err)
+
+	revocation := <-kinesumer.Revoked()
+	assert.Equal(t, "TestStream", revocation.StreamName)
+	assert.Equal(t, "shard0", revocation.ShardID)
+	assert.Equal(t, k.Revocation{ShardID: "shard0", LeaseEpoch: revocation.LeaseEpoch}, <-fencer.revoked)
+	assert.True(t, revocation.LeaseEpoch > 0)
+	kinesumer.End()
+}
+
+func TestKinesumerSeek(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+
+	assert.Error(t, kinesumer.Seek(context.Background(), "shard0", k.StartingPosition{Type: "TRIM_HORIZON"}))
+
+	iteratorTypes := make(chan string, 100)
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil).Run(func(args mock.Arguments) {
+		input := args.Get(1).(*kinesis.GetShardIteratorInput)
+		iteratorTypes <- aws.ToString(input.ShardId) + " " + string(input.ShardIteratorType) + " " +
+			aws.ToString(input.StartingSequenceNumber)
+	})
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	_, err := kinesumer.Begin()
+	assert.Nil(t, err)
+	// Both shards start from LATEST, as they have no checkpoints.
+	assert.Contains(t, []string{"shard0 LATEST ", "shard1 LATEST "}, <-iteratorTypes)
+	assert.Contains(t, []string{"shard0 LATEST ", "shard1 LATEST "}, <-iteratorTypes)
+
+	ctx := context.Background()
+	assert.Error(t, kinesumer.Seek(ctx, "shard0", k.StartingPosition{Type: "AT_SEQUENCE_NUMBER"}))
+	assert.Error(t, kinesumer.Seek(ctx, "shard0", k.StartingPosition{Type: "AT_TIMESTAMP"}))
+	assert.Error(t, kinesumer.Seek(ctx, "shard9", k.StartingPosition{Type: "LATEST"}))
+
+	assert.Nil(t, kinesumer.Seek(ctx, "shard1", k.StartingPosition{Type: "AT_SEQUENCE_NUMBER", SequenceNumber: "150"}))
+	assert.Equal(t, "shard1 AT_SEQUENCE_NUMBER 150", <-iteratorTypes)
+
+	kinesumer.End()
+}
+
+func TestKinesumerSeekLostShard(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Options.MaxShardWorkers = 1
+
+	failed := make(chan string, 100)
+	prov.On("TTL").Return(time.Hour)
+	prov.On("TryAcquire", mock.Anything, "shard0").Return(nil).Once()
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(errors.New("locked")).Run(func(args mock.Arguments) {
+		select {
+		case failed <- args.String(1):
+		default:
+		}
+	})
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	_, err := kinesumer.Begin()
+	assert.Nil(t, err)
+	for len(failed) > 0 {
+		<-failed
+	}
+
+	// A shard that another consumer is working on can't be seeked.
+	ctx := context.Background()
+	assert.Error(t, kinesumer.Seek(ctx, "shard1", k.StartingPosition{Type: "LATEST"}))
+
+	// A seeked shard whose lock is lost when it is restarted isn't read from the position later.
+	assert.Nil(t, kinesumer.Seek(ctx, "shard0", k.StartingPosition{Type: "TRIM_HORIZON"}))
+	for <-failed != "shard0" {
+	}
+	kinesumer.End()
+	assert.Empty(t, kinesumer.seekPositions)
+}
+
+func TestKinesumerRunStreamDeleted(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Options.ShardDiscoveryPeriod = time.Millisecond
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil).Twice()
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(
+		apiError("ResourceNotFoundException", "Stream TestStream not found"))
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return(k.ShardEnd, nil)
+	sssm.On("End").Return()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := kinesumer.Run(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, ECrit, err.(*Error).Severity())
+}
+
+// balancingProvisioner is a Provisioner that can count consumers and steal shards.
+type balancingProvisioner struct {
+	*mocks.Provisioner
+}
+
+func (p balancingProvisioner) Consumers(ctx context.Context, ttl time.Duration) (int, error) {
+	ret := p.Called(ctx, ttl)
+	return ret.Int(0), ret.Error(1)
+}
+
+func (p balancingProvisioner) Steal(ctx context.Context, shardID string) error {
+	return p.Called(ctx, shardID).Error(0)
+}
+
+func TestKinesumerBeginFairShare(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Provisioner = balancingProvisioner{prov}
+
+	prov.On("Consumers", mock.Anything, mock.Anything).Return(2, nil)
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("End").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	// Two consumers share the two shards.
+	n, err := kinesumer.Begin()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, len(kinesumer.workers))
+	kinesumer.End()
+}
+
+func TestKinesumerRebalance(t *testing.T) {
+	kinesumer, kin, sssm, prov := makeTestKinesumer(t)
+	kinesumer.Provisioner = balancingProvisioner{prov}
+	kinesumer.stopped = make(chan *ShardWorker)
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Steal", mock.Anything, "shard1").Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	sssm.On("Sync").Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("0"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shards, err := kinesumer.GetShards(context.Background())
+	assert.Nil(t, err)
+	worker0 := kinesumer.startWorker(ctx, shards[0])
+	worker1 := kinesumer.startWorker(ctx, shards[1])
+
+	// Another consumer joined, so one of the shards is handed off to it.
+	kinesumer.rebalance(ctx, 1, nil)
+	assert.Equal(t, 1, kinesumer.activeWorkers())
+	shed := <-kinesumer.stopped
+	assert.True(t, shed.shedding())
+	kinesumer.workerStopped(shed)
+	sssm.AssertCalled(t, "Sync")
+	prov.AssertCalled(t, "Release", mock.Anything, aws.ToString(shed.shard.ShardId))
+
+	kinesumer.rebalance(ctx, 1, nil)
+	assert.Equal(t, 1, kinesumer.activeWorkers())
+
+	// With no shards of its own, the consumer steals one so that the others count it.
+	kept := worker0
+	if shed == worker0 {
+		kept = worker1
+	}
+	kept.Shed()
+	kinesumer.workerStopped(<-kinesumer.stopped)
+	kinesumer.rebalance(ctx, 1, []types.Shard{shards[1]})
+	prov.AssertCalled(t, "Steal", mock.Anything, "shard1")
+	assert.Equal(t, 1, kinesumer.activeWorkers())
+	assert.NotNil(t, kinesumer.workers["shard1"])
+
+	cancel()
+	kinesumer.workerStopped(<-kinesumer.stopped)
+}
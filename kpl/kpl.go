@@ -0,0 +1,220 @@
+// Package kpl unpacks the Kinesis records that the Kinesis Producer Library aggregates many user
+// records into, and packs user records the same way.
+//
+// An aggregated record is a magic number, followed by an AggregatedRecord protocol buffer and its
+// MD5 checksum. See
+// https://github.com/awslabs/amazon-kinesis-producer/blob/master/aggregation-format.md
+package kpl
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+)
+
+// Magic is the prefix of an aggregated record's data.
+var Magic = []byte{0xf3, 0x89, 0x9a, 0xc2}
+
+// Field numbers of the AggregatedRecord and Record messages.
+const (
+	fieldPartitionKeyTable    = 1
+	fieldExplicitHashKeyTable = 2
+	fieldRecords              = 3
+
+	fieldPartitionKeyIndex    = 1
+	fieldExplicitHashKeyIndex = 2
+	fieldData                 = 3
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	errChecksum  = errors.New("Aggregated record checksum doesn't match")
+	errMalformed = errors.New("Aggregated record is malformed")
+)
+
+// UserRecord is one of the records that were aggregated into a Kinesis record.
+type UserRecord struct {
+	PartitionKey string
+	// ExplicitHashKey is empty if the record's shard was picked by hashing its partition key.
+	ExplicitHashKey string
+	Data            []byte
+}
+
+// IsAggregated reports whether data is an aggregated record.
+func IsAggregated(data []byte) bool {
+	return len(data) >= len(Magic)+md5.Size && bytes.HasPrefix(data, Magic)
+}
+
+// Deaggregate returns the user records aggregated into data, in order, so that a record's index is
+// its sub-sequence number. It returns an error if data isn't an aggregated record, or its checksum
+// doesn't match.
+func Deaggregate(data []byte) ([]*UserRecord, error) {
+	if !IsAggregated(data) {
+		return nil, errMalformed
+	}
+	msg := data[len(Magic) : len(data)-md5.Size]
+	sum := md5.Sum(msg)
+	if !bytes.Equal(sum[:], data[len(data)-md5.Size:]) {
+		return nil, errChecksum
+	}
+
+	var (
+		partitionKeys    []string
+		explicitHashKeys []string
+		records          [][]byte
+	)
+	err := readFields(msg, func(field int, wire int, value uint64, b []byte) error {
+		if wire != wireBytes {
+			return nil
+		}
+		switch field {
+		case fieldPartitionKeyTable:
+			partitionKeys = append(partitionKeys, string(b))
+		case fieldExplicitHashKeyTable:
+			explicitHashKeys = append(explicitHashKeys, string(b))
+		case fieldRecords:
+			records = append(records, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userRecords := make([]*UserRecord, len(records))
+	for i, b := range records {
+		r := &UserRecord{}
+		hasPartitionKey := false
+		err := readFields(b, func(field int, wire int, value uint64, b []byte) error {
+			switch {
+			case field == fieldPartitionKeyIndex && wire == wireVarint:
+				if value >= uint64(len(partitionKeys)) {
+					return errMalformed
+				}
+				r.PartitionKey = partitionKeys[value]
+				hasPartitionKey = true
+			case field == fieldExplicitHashKeyIndex && wire == wireVarint:
+				if value >= uint64(len(explicitHashKeys)) {
+					return errMalformed
+				}
+				r.ExplicitHashKey = explicitHashKeys[value]
+			case field == fieldData && wire == wireBytes:
+				r.Data = b
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !hasPartitionKey {
+			return nil, errMalformed
+		}
+		userRecords[i] = r
+	}
+	return userRecords, nil
+}
+
+// readFields calls fn with each field of a protocol buffer message: value holds varints, and b
+// holds length delimited fields.
+func readFields(msg []byte, fn func(field int, wire int, value uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errMalformed
+		}
+		msg = msg[n:]
+		field, wire := int(key>>3), int(key&7)
+
+		var (
+			value uint64
+			b     []byte
+		)
+		switch wire {
+		case wireVarint:
+			if value, n = binary.Uvarint(msg); n <= 0 {
+				return errMalformed
+			}
+			msg = msg[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wire == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errMalformed
+			}
+			msg = msg[size:]
+		case wireBytes:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || length > uint64(len(msg)-n) {
+				return errMalformed
+			}
+			b = msg[n : n+int(length)]
+			msg = msg[n+int(length):]
+		default:
+			return errMalformed
+		}
+
+		if err := fn(field, wire, value, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Aggregate packs records into the data of a single Kinesis record, which should be put with the
+// partition key of the first of them.
+func Aggregate(records []*UserRecord) []byte {
+	var (
+		msg              []byte
+		partitionKeys    = map[string]uint64{}
+		explicitHashKeys = map[string]uint64{}
+		body             []byte
+	)
+	for _, r := range records {
+		i, ok := partitionKeys[r.PartitionKey]
+		if !ok {
+			i = uint64(len(partitionKeys))
+			partitionKeys[r.PartitionKey] = i
+			msg = appendBytes(msg, fieldPartitionKeyTable, []byte(r.PartitionKey))
+		}
+
+		var record []byte
+		record = appendVarint(record, fieldPartitionKeyIndex, i)
+		if len(r.ExplicitHashKey) > 0 {
+			j, ok := explicitHashKeys[r.ExplicitHashKey]
+			if !ok {
+				j = uint64(len(explicitHashKeys))
+				explicitHashKeys[r.ExplicitHashKey] = j
+				msg = appendBytes(msg, fieldExplicitHashKeyTable, []byte(r.ExplicitHashKey))
+			}
+			record = appendVarint(record, fieldExplicitHashKeyIndex, j)
+		}
+		record = appendBytes(record, fieldData, r.Data)
+		body = appendBytes(body, fieldRecords, record)
+	}
+	msg = append(msg, body...)
+
+	sum := md5.Sum(msg)
+	data := append(append([]byte{}, Magic...), msg...)
+	return append(data, sum[:]...)
+}
+
+func appendVarint(msg []byte, field int, value uint64) []byte {
+	msg = binary.AppendUvarint(msg, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(msg, value)
+}
+
+func appendBytes(msg []byte, field int, b []byte) []byte {
+	msg = binary.AppendUvarint(msg, uint64(field)<<3|wireBytes)
+	msg = binary.AppendUvarint(msg, uint64(len(b)))
+	return append(msg, b...)
+}
@@ -0,0 +1,55 @@
+package kpl
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeaggregate(t *testing.T) {
+	// partition_key_table: "a", records: [{partition_key_index: 0, data: "x", tags: [{key: "k"}]}]
+	msg := []byte{0x0a, 0x01, 'a', 0x1a, 0x0a, 0x08, 0x00, 0x1a, 0x01, 'x', 0x22, 0x03, 0x0a, 0x01, 'k'}
+	sum := md5.Sum(msg)
+	data := append(append(append([]byte{}, Magic...), msg...), sum[:]...)
+
+	assert.True(t, IsAggregated(data))
+	records, err := Deaggregate(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []*UserRecord{{PartitionKey: "a", Data: []byte("x")}}, records)
+
+	data[len(data)-1]++
+	_, err = Deaggregate(data)
+	assert.Equal(t, errChecksum, err)
+}
+
+func TestAggregate(t *testing.T) {
+	records := []*UserRecord{
+		{PartitionKey: "a", Data: []byte("one")},
+		{PartitionKey: "b", ExplicitHashKey: "123", Data: []byte("two")},
+		{PartitionKey: "a", Data: []byte{}},
+	}
+
+	data := Aggregate(records)
+	assert.True(t, IsAggregated(data))
+	deaggregated, err := Deaggregate(data)
+	assert.NoError(t, err)
+	assert.Equal(t, len(records), len(deaggregated))
+	for i, r := range records {
+		assert.Equal(t, r.PartitionKey, deaggregated[i].PartitionKey)
+		assert.Equal(t, r.ExplicitHashKey, deaggregated[i].ExplicitHashKey)
+		assert.Equal(t, string(r.Data), string(deaggregated[i].Data))
+	}
+}
+
+func TestDeaggregateMalformed(t *testing.T) {
+	assert.False(t, IsAggregated([]byte("not aggregated")))
+	_, err := Deaggregate([]byte("not aggregated"))
+	assert.Error(t, err)
+
+	// The record's partition key index is out of range.
+	msg := []byte{0x1a, 0x02, 0x08, 0x01}
+	sum := md5.Sum(msg)
+	_, err = Deaggregate(append(append(append([]byte{}, Magic...), msg...), sum[:]...))
+	assert.Equal(t, errMalformed, err)
+}
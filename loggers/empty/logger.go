@@ -0,0 +1,7 @@
+package emptylogger
+
+type Logger struct {
+}
+
+func (l Logger) Log(string, string, ...interface{}) {
+}
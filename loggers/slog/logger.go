@@ -0,0 +1,51 @@
+// Package sloglogger adapts a log/slog Logger to receive a Kinesumer's messages.
+package sloglogger
+
+import (
+	"context"
+	"log/slog"
+
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// LevelCrit is the level critical messages are logged at, above slog.LevelError.
+const LevelCrit = slog.LevelError + 4
+
+type Logger struct {
+	logger *slog.Logger
+}
+
+// New returns a Logger that logs to logger, or slog.Default() if it is nil.
+func New(logger *slog.Logger) *Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Logger{logger: logger}
+}
+
+// Log logs msg at the level matching severity, with the severity as a field too, since slog has no
+// level of its own for critical messages.
+func (l *Logger) Log(severity, msg string, keyvals ...interface{}) {
+	level := Level(severity)
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	l.logger.Log(ctx, level, msg, append([]interface{}{"severity", severity}, keyvals...)...)
+}
+
+// Level returns the slog level that messages of a severity are logged at.
+func Level(severity string) slog.Level {
+	switch severity {
+	case k.ECrit:
+		return LevelCrit
+	case k.EError:
+		return slog.LevelError
+	case k.EWarn:
+		return slog.LevelWarn
+	case k.EDebug:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,35 @@
+package sloglogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerLog(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := New(slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	l.Log(k.EDebug, "dropped")
+	l.Log(k.EWarn, "Using LATEST", "stream", "events", "shard", "shard0")
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b.Bytes(), &line))
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "Using LATEST", line["msg"])
+	assert.Equal(t, "warn", line["severity"])
+	assert.Equal(t, "events", line["stream"])
+	assert.Equal(t, "shard0", line["shard"])
+}
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, LevelCrit, Level(k.ECrit))
+	assert.Equal(t, slog.LevelError, Level(k.EError))
+	assert.Equal(t, slog.LevelWarn, Level(k.EWarn))
+	assert.Equal(t, slog.LevelInfo, Level(k.EInfo))
+	assert.Equal(t, slog.LevelDebug, Level(k.EDebug))
+}
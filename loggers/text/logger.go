@@ -0,0 +1,49 @@
+// Package textlogger writes log messages as lines of text, followed by their fields as key=value
+// pairs.
+package textlogger
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Logger struct {
+	mut sync.Mutex
+	w   io.Writer
+}
+
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Log writes a line like:
+//
+//	warn: Using LATEST stream=events shard=shardId-000000000000
+func (l *Logger) Log(severity, msg string, keyvals ...interface{}) {
+	b := &strings.Builder{}
+	b.WriteString(severity + ": " + msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var val interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		b.WriteString(" " + key + "=" + quote(fmt.Sprint(val)))
+	}
+	b.WriteString("\n")
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// quote quotes values that would otherwise be ambiguous.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
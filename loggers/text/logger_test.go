@@ -0,0 +1,21 @@
+package textlogger
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerLog(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := New(b)
+
+	l.Log("warn", "Using LATEST")
+	l.Log("error", "GetRecords failed", "stream", "events", "shard", "shardId-000000000000", "sequence", 123)
+	l.Log("info", "quoted", "reason", "has spaces", "empty", "", "odd")
+
+	assert.Equal(t, "warn: Using LATEST\n"+
+		"error: GetRecords failed stream=events shard=shardId-000000000000 sequence=123\n"+
+		"info: quoted reason=\"has spaces\" empty=\"\" odd=!MISSING\n", b.String())
+}
@@ -0,0 +1,29 @@
+package emptymetrics
+
+import (
+	"time"
+)
+
+type Metrics struct {
+}
+
+func (m Metrics) RecordsRead(string, int, int, int64) {
+}
+
+func (m Metrics) GetRecords(string, time.Duration, error) {
+}
+
+func (m Metrics) CheckpointSaved(time.Duration, error) {
+}
+
+func (m Metrics) LockAcquired(string, error) {
+}
+
+func (m Metrics) Heartbeat(string, error) {
+}
+
+func (m Metrics) ShardsOwned(int) {
+}
+
+func (m Metrics) ShardReleased(string) {
+}
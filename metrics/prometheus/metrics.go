@@ -0,0 +1,272 @@
+// Package prometheusmetrics exports a Kinesumer's metrics in the Prometheus text format, so that
+// a consumer can be scraped without depending on the Prometheus client library.
+package prometheusmetrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms' buckets.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects a Kinesumer's metrics and serves them to Prometheus:
+//
+//	<namespace>_millis_behind_latest{shard}              gauge
+//	<namespace>_records_read_total{shard}                counter
+//	<namespace>_bytes_read_total{shard}                  counter
+//	<namespace>_get_records_duration_seconds{shard}      histogram
+//	<namespace>_get_records_errors_total{shard}          counter
+//	<namespace>_checkpoint_duration_seconds              histogram
+//	<namespace>_checkpoint_errors_total                  counter
+//	<namespace>_lock_acquire_failures_total              counter
+//	<namespace>_heartbeat_failures_total{shard}          counter
+//	<namespace>_shards_owned                             gauge
+type Metrics struct {
+	namespace string
+	buckets   []float64
+
+	mut                 sync.Mutex
+	millisBehindLatest  map[string]float64
+	recordsRead         map[string]float64
+	bytesRead           map[string]float64
+	getRecordsDuration  map[string]*histogram
+	getRecordsErrors    map[string]float64
+	checkpointDuration  *histogram
+	checkpointErrors    float64
+	lockAcquireFailures float64
+	heartbeatFailures   map[string]float64
+	shardsOwned         float64
+}
+
+type histogram struct {
+	// counts holds the number of observations in each bucket, and those above the last bucket.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type Options struct {
+	// The zero value is "kinesumer".
+	Namespace string
+	// The zero value is DefaultBuckets.
+	Buckets []float64
+}
+
+func New(opt *Options) *Metrics {
+	if opt == nil {
+		opt = &Options{}
+	}
+	if opt.Namespace == "" {
+		opt.Namespace = "kinesumer"
+	}
+	if len(opt.Buckets) == 0 {
+		opt.Buckets = DefaultBuckets
+	}
+
+	m := &Metrics{
+		namespace:          opt.Namespace,
+		buckets:            opt.Buckets,
+		millisBehindLatest: make(map[string]float64),
+		recordsRead:        make(map[string]float64),
+		bytesRead:          make(map[string]float64),
+		getRecordsDuration: make(map[string]*histogram),
+		getRecordsErrors:   make(map[string]float64),
+		heartbeatFailures:  make(map[string]float64),
+	}
+	m.checkpointDuration = m.newHistogram()
+	return m
+}
+
+func (m *Metrics) newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(m.buckets)+1)}
+}
+
+func (m *Metrics) observe(h *histogram, latency time.Duration) {
+	seconds := latency.Seconds()
+	i := sort.SearchFloat64s(m.buckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) RecordsRead(shardID string, records, bytes int, millisBehindLatest int64) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.recordsRead[shardID] += float64(records)
+	m.bytesRead[shardID] += float64(bytes)
+	m.millisBehindLatest[shardID] = float64(millisBehindLatest)
+}
+
+func (m *Metrics) GetRecords(shardID string, latency time.Duration, err error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	h := m.getRecordsDuration[shardID]
+	if h == nil {
+		h = m.newHistogram()
+		m.getRecordsDuration[shardID] = h
+	}
+	m.observe(h, latency)
+	if err != nil {
+		m.getRecordsErrors[shardID]++
+	}
+}
+
+func (m *Metrics) CheckpointSaved(latency time.Duration, err error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.observe(m.checkpointDuration, latency)
+	if err != nil {
+		m.checkpointErrors++
+	}
+}
+
+func (m *Metrics) LockAcquired(shardID string, err error) {
+	if err == nil {
+		return
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.lockAcquireFailures++
+}
+
+func (m *Metrics) Heartbeat(shardID string, err error) {
+	if err == nil {
+		return
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.heartbeatFailures[shardID]++
+}
+
+func (m *Metrics) ShardsOwned(n int) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.shardsOwned = float64(n)
+}
+
+// ShardReleased drops the shard's series, so that the shards that a consumer has owned over time
+// don't pile up.
+func (m *Metrics) ShardReleased(shardID string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.millisBehindLatest, shardID)
+	delete(m.recordsRead, shardID)
+	delete(m.bytesRead, shardID)
+	delete(m.getRecordsDuration, shardID)
+	delete(m.getRecordsErrors, shardID)
+	delete(m.heartbeatFailures, shardID)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format, so that Metrics can be mounted at
+// /metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	b := &strings.Builder{}
+	m.writeByShard(b, "millis_behind_latest", "gauge", "How far each shard's reader is behind the tip of the shard.", m.millisBehindLatest)
+	m.writeByShard(b, "records_read_total", "counter", "Records read from each shard.", m.recordsRead)
+	m.writeByShard(b, "bytes_read_total", "counter", "Bytes of record data read from each shard.", m.bytesRead)
+
+	name := m.namespace + "_get_records_duration_seconds"
+	writeHeader(b, name, "histogram", "How long GetRecords requests took.")
+	shardIDs := make([]string, 0, len(m.getRecordsDuration))
+	for shardID := range m.getRecordsDuration {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Strings(shardIDs)
+	for _, shardID := range shardIDs {
+		m.writeHistogram(b, name, `shard="`+escape(shardID)+`"`, m.getRecordsDuration[shardID])
+	}
+
+	m.writeByShard(b, "get_records_errors_total", "counter", "GetRecords requests that failed.", m.getRecordsErrors)
+
+	name = m.namespace + "_checkpoint_duration_seconds"
+	writeHeader(b, name, "histogram", "How long writing checkpoints took.")
+	m.writeHistogram(b, name, "", m.checkpointDuration)
+
+	name = m.namespace + "_checkpoint_errors_total"
+	writeHeader(b, name, "counter", "Checkpoint writes that failed.")
+	writeSample(b, name, "", m.checkpointErrors)
+
+	// Lock failures aren't counted by shard, as they are mostly for shards that other consumers
+	// own, whose series would never be dropped by ShardReleased.
+	name = m.namespace + "_lock_acquire_failures_total"
+	writeHeader(b, name, "counter", "Attempts to acquire the lock on a shard that failed.")
+	writeSample(b, name, "", m.lockAcquireFailures)
+	m.writeByShard(b, "heartbeat_failures_total", "counter", "Heartbeats on the lock on a shard that failed.", m.heartbeatFailures)
+
+	name = m.namespace + "_shards_owned"
+	writeHeader(b, name, "gauge", "Shards being worked on.")
+	writeSample(b, name, "", m.shardsOwned)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) writeByShard(b *strings.Builder, name, typ, help string, values map[string]float64) {
+	name = m.namespace + "_" + name
+	writeHeader(b, name, typ, help)
+	for _, shardID := range sortedKeys(values) {
+		writeSample(b, name, `shard="`+escape(shardID)+`"`, values[shardID])
+	}
+}
+
+func (m *Metrics) writeHistogram(b *strings.Builder, name, labels string, h *histogram) {
+	sep := ""
+	if len(labels) > 0 {
+		sep = ","
+	}
+
+	var cumulative uint64
+	for i, upper := range m.buckets {
+		cumulative += h.counts[i]
+		writeSample(b, name+"_bucket", labels+sep+`le="`+formatFloat(upper)+`"`, float64(cumulative))
+	}
+	writeSample(b, name+"_bucket", labels+sep+`le="+Inf"`, float64(h.count))
+	writeSample(b, name+"_sum", labels, h.sum)
+	writeSample(b, name+"_count", labels, float64(h.count))
+}
+
+func writeHeader(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(b *strings.Builder, name, labels string, value float64) {
+	if len(labels) > 0 {
+		fmt.Fprintf(b, "%s{%s} %s\n", name, labels, formatFloat(value))
+	} else {
+		fmt.Fprintf(b, "%s %s\n", name, formatFloat(value))
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// escape escapes a label value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,87 @@
+package prometheusmetrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New(&Options{Buckets: []float64{.1, 1}})
+
+	m.RecordsRead("shard0", 2, 10, 500)
+	m.RecordsRead("shard0", 1, 5, 100)
+	m.GetRecords("shard0", 50*time.Millisecond, nil)
+	m.GetRecords("shard0", 2*time.Second, errors.New("throttled"))
+	m.CheckpointSaved(500*time.Millisecond, nil)
+	m.LockAcquired("shard1", errors.New("locked"))
+	m.LockAcquired("shard0", nil)
+	m.Heartbeat("shard0", nil)
+	m.ShardsOwned(1)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	assert.Contains(t, w.Header().Get("Content-Type"), "version=0.0.4")
+	for _, line := range []string{
+		"# TYPE kinesumer_millis_behind_latest gauge\n",
+		`kinesumer_millis_behind_latest{shard="shard0"} 100` + "\n",
+		`kinesumer_records_read_total{shard="shard0"} 3` + "\n",
+		`kinesumer_bytes_read_total{shard="shard0"} 15` + "\n",
+		"# TYPE kinesumer_get_records_duration_seconds histogram\n",
+		`kinesumer_get_records_duration_seconds_bucket{shard="shard0",le="0.1"} 1` + "\n",
+		`kinesumer_get_records_duration_seconds_bucket{shard="shard0",le="1"} 1` + "\n",
+		`kinesumer_get_records_duration_seconds_bucket{shard="shard0",le="+Inf"} 2` + "\n",
+		`kinesumer_get_records_duration_seconds_sum{shard="shard0"} 2.05` + "\n",
+		`kinesumer_get_records_duration_seconds_count{shard="shard0"} 2` + "\n",
+		`kinesumer_get_records_errors_total{shard="shard0"} 1` + "\n",
+		`kinesumer_checkpoint_duration_seconds_bucket{le="1"} 1` + "\n",
+		"kinesumer_checkpoint_errors_total 0\n",
+		"kinesumer_lock_acquire_failures_total 1\n",
+		"kinesumer_shards_owned 1\n",
+	} {
+		assert.Contains(t, body, line)
+	}
+	assert.NotContains(t, body, `kinesumer_heartbeat_failures_total{`)
+}
+
+func TestMetricsShardReleased(t *testing.T) {
+	m := New(nil)
+
+	for _, shardID := range []string{"shard0", "shard1"} {
+		m.RecordsRead(shardID, 2, 10, 500)
+		m.GetRecords(shardID, 50*time.Millisecond, errors.New("throttled"))
+		m.LockAcquired(shardID, errors.New("locked"))
+		m.Heartbeat(shardID, errors.New("lost"))
+	}
+	m.ShardReleased("shard0")
+
+	w := httptest.NewRecorder()
+	m.WriteTo(w)
+	body := w.Body.String()
+	assert.NotContains(t, body, `shard="shard0"`)
+	assert.Contains(t, body, "kinesumer_lock_acquire_failures_total 2\n")
+	for _, name := range []string{
+		"millis_behind_latest",
+		"records_read_total",
+		"bytes_read_total",
+		"get_records_duration_seconds_count",
+		"get_records_errors_total",
+		"heartbeat_failures_total",
+	} {
+		assert.Contains(t, body, "kinesumer_"+name+`{shard="shard1"}`)
+	}
+}
+
+func TestMetricsNamespace(t *testing.T) {
+	m := New(&Options{Namespace: "app"})
+	m.ShardsOwned(2)
+
+	w := httptest.NewRecorder()
+	m.WriteTo(w)
+	assert.Contains(t, w.Body.String(), "app_shards_owned 2\n")
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	k "github.com/remind101/kinesumer/interface"
 	"github.com/stretchr/testify/mock"
 )
@@ -9,6 +11,9 @@ type Checkpointer struct {
 	mock.Mock
 }
 
+func (m *Checkpointer) Track(record k.Record) {
+	m.Called(record)
+}
 func (m *Checkpointer) DoneC() chan<- k.Record {
 	ret := m.Called()
 
@@ -19,8 +24,8 @@ func (m *Checkpointer) DoneC() chan<- k.Record {
 
 	return r0
 }
-func (m *Checkpointer) Begin() error {
-	ret := m.Called()
+func (m *Checkpointer) Begin(ctx context.Context) error {
+	ret := m.Called(ctx)
 
 	r0 := ret.Error(0)
 
@@ -29,12 +34,13 @@ func (m *Checkpointer) Begin() error {
 func (m *Checkpointer) End() {
 	m.Called()
 }
-func (m *Checkpointer) GetStartSequence(shardID string) string {
-	ret := m.Called(shardID)
+func (m *Checkpointer) GetStartSequence(ctx context.Context, shardID string) (string, error) {
+	ret := m.Called(ctx, shardID)
 
 	r0 := ret.String(0)
+	r1 := ret.Error(1)
 
-	return r0
+	return r0, r1
 }
 func (m *Checkpointer) Sync() {
 	m.Called()
@@ -0,0 +1,20 @@
+package mocks
+
+import (
+	"context"
+
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/stretchr/testify/mock"
+)
+
+type DeadLetter struct {
+	mock.Mock
+}
+
+func (m *DeadLetter) Park(ctx context.Context, record k.Record, reason error) error {
+	ret := m.Called(ctx, record, reason)
+
+	r0 := ret.Error(0)
+
+	return r0
+}
@@ -0,0 +1,79 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/remind101/kinesumer/dynamodbclient"
+	"github.com/stretchr/testify/mock"
+)
+
+type DynamoDB struct {
+	mock.Mock
+}
+
+func (m *DynamoDB) CreateTable(ctx context.Context, input *dynamodbclient.CreateTableInput) (*dynamodbclient.CreateTableOutput, error) {
+	ret := m.Called(ctx, input)
+
+	var r0 *dynamodbclient.CreateTableOutput
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dynamodbclient.CreateTableOutput)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
+func (m *DynamoDB) DescribeTable(ctx context.Context, input *dynamodbclient.DescribeTableInput) (*dynamodbclient.DescribeTableOutput, error) {
+	ret := m.Called(ctx, input)
+
+	var r0 *dynamodbclient.DescribeTableOutput
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dynamodbclient.DescribeTableOutput)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
+func (m *DynamoDB) GetItem(ctx context.Context, input *dynamodbclient.GetItemInput) (*dynamodbclient.GetItemOutput, error) {
+	ret := m.Called(ctx, input)
+
+	var r0 *dynamodbclient.GetItemOutput
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dynamodbclient.GetItemOutput)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
+func (m *DynamoDB) PutItem(ctx context.Context, input *dynamodbclient.PutItemInput) (*dynamodbclient.PutItemOutput, error) {
+	ret := m.Called(ctx, input)
+
+	var r0 *dynamodbclient.PutItemOutput
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dynamodbclient.PutItemOutput)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
+func (m *DynamoDB) UpdateItem(ctx context.Context, input *dynamodbclient.UpdateItemInput) (*dynamodbclient.UpdateItemOutput, error) {
+	ret := m.Called(ctx, input)
+
+	var r0 *dynamodbclient.UpdateItemOutput
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dynamodbclient.UpdateItemOutput)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
+func (m *DynamoDB) Scan(ctx context.Context, input *dynamodbclient.ScanInput) (*dynamodbclient.ScanOutput, error) {
+	ret := m.Called(ctx, input)
+
+	var r0 *dynamodbclient.ScanOutput
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dynamodbclient.ScanOutput)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
@@ -0,0 +1,32 @@
+package mocks
+
+import (
+	"context"
+
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/stretchr/testify/mock"
+)
+
+type FanOut struct {
+	mock.Mock
+}
+
+func (m *FanOut) RegisterStreamConsumer(ctx context.Context, stream, consumerName string) (string, error) {
+	ret := m.Called(ctx, stream, consumerName)
+
+	r0 := ret.String(0)
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
+func (m *FanOut) SubscribeToShard(ctx context.Context, consumerARN, shardID string, position k.StartingPosition) (k.ShardSubscription, error) {
+	ret := m.Called(ctx, consumerARN, shardID, position)
+
+	var r0 k.ShardSubscription
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(k.ShardSubscription)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	k "github.com/remind101/kinesumer/interface"
 	"github.com/stretchr/testify/mock"
 )
@@ -9,6 +11,13 @@ type Kinesumer struct {
 	mock.Mock
 }
 
+func (m *Kinesumer) Run(ctx context.Context) error {
+	ret := m.Called(ctx)
+
+	r0 := ret.Error(0)
+
+	return r0
+}
 func (m *Kinesumer) Begin() (int, error) {
 	ret := m.Called()
 
@@ -30,3 +39,33 @@ func (m *Kinesumer) Records() <-chan k.Record {
 
 	return r0
 }
+func (m *Kinesumer) Shards() <-chan k.ShardStream {
+	ret := m.Called()
+
+	var r0 <-chan k.ShardStream
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(<-chan k.ShardStream)
+	}
+
+	return r0
+}
+func (m *Kinesumer) Revoked() <-chan k.Revocation {
+	ret := m.Called()
+
+	var r0 <-chan k.Revocation
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(<-chan k.Revocation)
+	}
+
+	return r0
+}
+func (m *Kinesumer) Errors() <-chan k.Error {
+	ret := m.Called()
+
+	var r0 <-chan k.Error
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(<-chan k.Error)
+	}
+
+	return r0
+}
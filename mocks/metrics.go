@@ -0,0 +1,33 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type Metrics struct {
+	mock.Mock
+}
+
+func (m *Metrics) RecordsRead(shardID string, records, bytes int, millisBehindLatest int64) {
+	m.Called(shardID, records, bytes, millisBehindLatest)
+}
+func (m *Metrics) GetRecords(shardID string, latency time.Duration, err error) {
+	m.Called(shardID, latency, err)
+}
+func (m *Metrics) CheckpointSaved(latency time.Duration, err error) {
+	m.Called(latency, err)
+}
+func (m *Metrics) LockAcquired(shardID string, err error) {
+	m.Called(shardID, err)
+}
+func (m *Metrics) Heartbeat(shardID string, err error) {
+	m.Called(shardID, err)
+}
+func (m *Metrics) ShardsOwned(n int) {
+	m.Called(n)
+}
+func (m *Metrics) ShardReleased(shardID string) {
+	m.Called(shardID)
+}
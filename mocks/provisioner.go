@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"context"
 	"time"
 
 	"github.com/stretchr/testify/mock"
@@ -10,22 +11,22 @@ type Provisioner struct {
 	mock.Mock
 }
 
-func (m *Provisioner) TryAcquire(shardID string) error {
-	ret := m.Called(shardID)
+func (m *Provisioner) TryAcquire(ctx context.Context, shardID string) error {
+	ret := m.Called(ctx, shardID)
 
 	r0 := ret.Error(0)
 
 	return r0
 }
-func (m *Provisioner) Release(shardID string) error {
-	ret := m.Called(shardID)
+func (m *Provisioner) Release(ctx context.Context, shardID string) error {
+	ret := m.Called(ctx, shardID)
 
 	r0 := ret.Error(0)
 
 	return r0
 }
-func (m *Provisioner) Heartbeat(shardID string) error {
-	ret := m.Called(shardID)
+func (m *Provisioner) Heartbeat(ctx context.Context, shardID string) error {
+	ret := m.Called(ctx, shardID)
 
 	r0 := ret.Error(0)
 
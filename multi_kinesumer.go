@@ -0,0 +1,457 @@
+package kinesumer
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/remind101/kinesumer/checkpointers/empty"
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/provisioners/empty"
+)
+
+// MultiKinesumer reads several streams with one checkpointer and provisioner. Each stream is read
+// by its own Kinesumer, which locks and checkpoints its shards under the ShardKey of the stream
+// and shard so that the streams' shard IDs don't collide. The records of every stream are sent on
+// Records, and MaxShardWorkers limits the number of workers across all of the streams.
+type MultiKinesumer struct {
+	Kinesis      k.Kinesis
+	Checkpointer k.Checkpointer
+	Provisioner  k.Provisioner
+	Options      *Options
+
+	// Streams are the names or ARNs of the streams to read. A critical error on one of them, such
+	// as it being deleted, makes Run return.
+	Streams []string
+
+	// If StreamPattern is set, the streams listed by Kinesis whose names match it are read too.
+	// The streams are listed again every ShardDiscoveryPeriod, so that matching streams that are
+	// created are read and those that are deleted stop being read.
+	StreamPattern *regexp.Regexp
+
+	// FanOut is used to subscribe to shards when Options.ConsumerName is set. If it is nil, one is
+	// made from each stream's client.
+	FanOut k.FanOut
+
+	// If StreamKinesis is set, each stream is read with the client that it returns for the stream,
+	// such as Clients.Kinesis for streams in other accounts. Kinesis is still used to list streams.
+	StreamKinesis func(stream string) (k.Kinesis, error)
+
+	records    chan k.Record
+	shards     chan k.ShardStream
+	revoked    chan k.Revocation
+	errors     chan k.Error
+	fatal      chan k.Error
+	cancel     context.CancelFunc
+	discovered chan Unit
+
+	// mut guards kinesumers, the Kinesumer reading each stream, and rand.
+	mut        sync.Mutex
+	kinesumers map[string]*Kinesumer
+	rand       *rand.Rand
+
+	// workersMut guards workers, the number of places reserved for workers under MaxShardWorkers,
+	// and owned, the number of shards being worked on.
+	workersMut sync.Mutex
+	workers    int
+	owned      int
+}
+
+func NewMulti(kinesis k.Kinesis, checkpointer k.Checkpointer, provisioner k.Provisioner,
+	randSource rand.Source, streams []string, pattern *regexp.Regexp, opt *Options,
+	duration time.Duration) (*MultiKinesumer, error) {
+
+	if kinesis == nil {
+		return nil, NewError(ECrit, "Kinesis object must not be nil", nil)
+	}
+
+	if checkpointer == nil {
+		checkpointer = emptycheckpointer.Checkpointer{}
+	}
+
+	if provisioner == nil {
+		provisioner = emptyprovisioner.Provisioner{}
+	}
+
+	if randSource == nil {
+		randSource = rand.NewSource(time.Now().UnixNano())
+	}
+
+	if len(streams) == 0 && pattern == nil {
+		return nil, NewError(ECrit, "Stream names or a stream pattern must be given", nil)
+	}
+	for _, stream := range streams {
+		if len(stream) == 0 {
+			return nil, NewError(ECrit, "Stream name can't be empty", nil)
+		}
+	}
+
+	opt = withDefaults(opt, duration)
+
+	return &MultiKinesumer{
+		Kinesis:       kinesis,
+		Checkpointer:  checkpointer,
+		Provisioner:   provisioner,
+		Options:       opt,
+		Streams:       streams,
+		StreamPattern: pattern,
+		records:       make(chan k.Record, opt.GetRecordsLimit*2+10),
+		shards:        make(chan k.ShardStream),
+		revoked:       make(chan k.Revocation, errorsBuffer),
+		errors:        make(chan k.Error, errorsBuffer),
+		kinesumers:    make(map[string]*Kinesumer),
+		rand:          rand.New(randSource),
+	}, nil
+}
+
+// GetStreams returns the names of the streams to read: Streams, followed by the streams listed by
+// Kinesis whose names match StreamPattern.
+func (m *MultiKinesumer) GetStreams(ctx context.Context) ([]string, error) {
+	streams := append([]string(nil), m.Streams...)
+	if m.StreamPattern == nil {
+		return streams, nil
+	}
+
+	// A stream given by ARN is matched by its name, so that it isn't read twice.
+	named := make(map[string]bool, len(m.Streams))
+	for _, stream := range m.Streams {
+		named[streamName(stream)] = true
+	}
+	listed, err := listStreams(ctx, m.Kinesis, m.Options.ListStreamsLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range listed {
+		if !named[stream] && m.StreamPattern.MatchString(stream) {
+			streams = append(streams, stream)
+		}
+	}
+	return streams, nil
+}
+
+// named reports whether stream is one of Streams.
+func (m *MultiKinesumer) named(stream string) bool {
+	for _, s := range m.Streams {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// Run consumes the streams until ctx is done or a critical error occurs on one of Streams, and
+// then stops all of the workers. It returns the critical error, or nil if ctx is done.
+func (m *MultiKinesumer) Run(ctx context.Context) error {
+	m.fatal = make(chan k.Error, 1)
+
+	if _, err := m.begin(ctx); err != nil {
+		m.End()
+		return err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-m.fatal:
+	}
+
+	m.End()
+	return err
+}
+
+func (m *MultiKinesumer) Begin() (int, error) {
+	return m.BeginContext(context.Background())
+}
+
+// BeginContext starts consuming the streams and returns the number of shard workers that were
+// started. The workers run until End is called or ctx is done. If one of Streams can't be read,
+// the error is returned and End must still be called. Streams that only match StreamPattern and
+// can't be read are reported on Errors instead.
+func (m *MultiKinesumer) BeginContext(ctx context.Context) (int, error) {
+	n, err := m.begin(ctx)
+	if err != nil {
+		return n, err
+	}
+
+	if n < 1 {
+		return n, NewError(EWarn, "0 shard workers started", nil)
+	}
+
+	return n, nil
+}
+
+func (m *MultiKinesumer) begin(ctx context.Context) (int, error) {
+	streams, err := m.GetStreams(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.Checkpointer.Begin(ctx); err != nil {
+		return 0, err
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.discovered = make(chan Unit)
+
+	counts := make([]int, len(streams))
+	errs := make([]error, len(streams))
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		wg.Add(1)
+		go func(i int, stream string) {
+			defer wg.Done()
+			counts[i], errs[i] = m.beginStream(ctx, stream)
+		}(i, stream)
+	}
+	wg.Wait()
+
+	n := 0
+	for i, err := range errs {
+		if err == nil {
+			n += counts[i]
+			continue
+		}
+		if m.named(streams[i]) {
+			m.endKinesumers()
+			close(m.discovered)
+			return 0, err
+		}
+		m.report(NewError(EWarn, "Could not begin reading stream", err).With("stream", streams[i]))
+	}
+
+	go m.discoverStreams(ctx)
+
+	return n, nil
+}
+
+// beginStream starts a Kinesumer reading stream, and returns the number of shard workers that it
+// started.
+func (m *MultiKinesumer) beginStream(ctx context.Context, stream string) (int, error) {
+	client := m.Kinesis
+	if m.StreamKinesis != nil {
+		var err error
+		if client, err = m.StreamKinesis(stream); err != nil {
+			return 0, err
+		}
+	}
+
+	m.mut.Lock()
+	kin, err := New(client, m.Checkpointer, m.Provisioner, rand.NewSource(m.rand.Int63()), stream, m.Options, 0)
+	m.mut.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	kin.parent = m
+	kin.FanOut = m.FanOut
+	kin.records = m.records
+	kin.shards = m.shards
+	kin.revoked = m.revoked
+	kin.errors = m.errors
+	if m.named(stream) {
+		kin.fatal = m.fatal
+	}
+
+	n, err := kin.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mut.Lock()
+	m.kinesumers[stream] = kin
+	m.mut.Unlock()
+	return n, nil
+}
+
+// discoverStreams periodically lists the streams if there is a StreamPattern, and starts reading
+// the streams that have come to match it and stops reading those that no longer exist.
+func (m *MultiKinesumer) discoverStreams(ctx context.Context) {
+	defer close(m.discovered)
+	if m.StreamPattern == nil {
+		return
+	}
+
+	period := m.Options.ShardDiscoveryPeriod
+	if period == 0 {
+		period = DefaultShardDiscoveryPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			streams, err := m.GetStreams(ctx)
+			if err != nil {
+				m.report(NewError(EWarn, "Could not list streams", err))
+				continue
+			}
+
+			listed := make(map[string]bool, len(streams))
+			for _, stream := range streams {
+				listed[stream] = true
+				if m.kinesumer(stream) != nil {
+					continue
+				}
+				if _, err := m.beginStream(ctx, stream); err != nil {
+					m.report(NewError(EWarn, "Could not begin reading stream", err).With("stream", stream))
+					continue
+				}
+				m.Options.ErrHandler(NewError(EInfo, "Started reading stream", nil).With("stream", stream))
+			}
+
+			for stream, kin := range m.Kinesumers() {
+				if !listed[stream] {
+					kin.End()
+					m.mut.Lock()
+					delete(m.kinesumers, stream)
+					m.mut.Unlock()
+					m.Options.ErrHandler(NewError(EInfo, "Stopped reading stream", nil).With("stream", stream))
+				}
+			}
+		}
+	}
+}
+
+// Kinesumers returns the Kinesumer reading each stream, by the stream's name.
+func (m *MultiKinesumer) Kinesumers() map[string]*Kinesumer {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	kinesumers := make(map[string]*Kinesumer, len(m.kinesumers))
+	for stream, kin := range m.kinesumers {
+		kinesumers[stream] = kin
+	}
+	return kinesumers
+}
+
+func (m *MultiKinesumer) kinesumer(stream string) *Kinesumer {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.kinesumers[stream]
+}
+
+// reserveWorker reserves a place for a worker under MaxShardWorkers, returning false if there is
+// none.
+func (m *MultiKinesumer) reserveWorker() bool {
+	m.workersMut.Lock()
+	defer m.workersMut.Unlock()
+	if max := m.Options.MaxShardWorkers; max > 0 && m.workers >= max {
+		return false
+	}
+	m.workers++
+	return true
+}
+
+// releaseWorker gives up a place reserved by reserveWorker.
+func (m *MultiKinesumer) releaseWorker() {
+	m.workersMut.Lock()
+	defer m.workersMut.Unlock()
+	m.workers--
+}
+
+// shardsOwned adds delta to the number of shards being worked on, and returns it.
+func (m *MultiKinesumer) shardsOwned(delta int) int {
+	m.workersMut.Lock()
+	defer m.workersMut.Unlock()
+	m.owned += delta
+	return m.owned
+}
+
+// report passes err to the ErrHandler and sends it to the Errors channel.
+func (m *MultiKinesumer) report(err k.Error) {
+	m.Options.ErrHandler(err)
+
+	select {
+	case m.errors <- err:
+	default:
+	}
+}
+
+// End stops reading every stream, waits for the workers to exit and then ends the checkpointer.
+// Calling End again, such as after Run has returned, does nothing.
+func (m *MultiKinesumer) End() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.discovered
+	m.endKinesumers()
+	m.cancel = nil
+	close(m.shards)
+	m.Checkpointer.End()
+}
+
+// endKinesumers stops reading every stream, and waits for the workers to exit.
+func (m *MultiKinesumer) endKinesumers() {
+	m.cancel()
+
+	var wg sync.WaitGroup
+	for _, kin := range m.Kinesumers() {
+		wg.Add(1)
+		go func(kin *Kinesumer) {
+			defer wg.Done()
+			kin.End()
+		}(kin)
+	}
+	wg.Wait()
+
+	m.mut.Lock()
+	m.kinesumers = make(map[string]*Kinesumer)
+	m.mut.Unlock()
+}
+
+// Records returns the channel that the records of every stream are sent on. Each record's
+// StreamName is the stream it was read from.
+func (m *MultiKinesumer) Records() <-chan k.Record {
+	return m.records
+}
+
+// Shards returns a channel on which each shard that is acquired, of any of the streams, is
+// delivered as its own ShardStream if Options.ShardStreams is set. It is closed by End.
+func (m *MultiKinesumer) Shards() <-chan k.ShardStream {
+	return m.shards
+}
+
+// Revoked returns a channel on which a Revocation is sent when the lease on a shard of any of the
+// streams is lost.
+func (m *MultiKinesumer) Revoked() <-chan k.Revocation {
+	return m.revoked
+}
+
+// Errors returns a channel of the errors encountered while consuming the streams.
+func (m *MultiKinesumer) Errors() <-chan k.Error {
+	return m.errors
+}
+
+// Seek makes the shard with shardID of stream, or every shard of stream if shardID is empty, be
+// read from position instead of its checkpoint. See Kinesumer.Seek.
+func (m *MultiKinesumer) Seek(ctx context.Context, stream, shardID string, position k.StartingPosition) error {
+	kin := m.kinesumer(stream)
+	if kin == nil {
+		return NewError(EWarn, "Unknown stream "+stream, nil)
+	}
+	return kin.Seek(ctx, shardID, position)
+}
+
+// listStreams returns the names of the streams in the account and region of the client.
+func listStreams(ctx context.Context, client k.Kinesis, limit int64) ([]string, error) {
+	streams := make([]string, 0)
+	input := &kinesis.ListStreamsInput{Limit: aws.Int32(int32(limit))}
+	for {
+		out, err := client.ListStreams(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, out.StreamNames...)
+		if !aws.ToBool(out.HasMoreStreams) || out.NextToken == nil {
+			return streams, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
@@ -0,0 +1,230 @@
+package kinesumer
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeTestMultiKinesumer(t *testing.T, streams []string, pattern *regexp.Regexp) (*MultiKinesumer,
+	*mocks.Kinesis, *mocks.Checkpointer, *mocks.Provisioner) {
+	kin := new(mocks.Kinesis)
+	sssm := new(mocks.Checkpointer)
+	prov := new(mocks.Provisioner)
+	opt := DefaultOptions
+	m, err := NewMulti(kin, sssm, prov, rand.NewSource(0), streams, pattern, &opt, 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	prov.On("TTL").Return(time.Millisecond * 10)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Begin", mock.Anything).Return(nil)
+	sssm.On("End").Return()
+	sssm.On("Track", mock.Anything).Return()
+	sssm.On("DoneC").Return(make(chan k.Record, 10))
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("AAAA"),
+	}, nil)
+	return m, kin, sssm, prov
+}
+
+func TestNewMulti(t *testing.T) {
+	_, err := NewMulti(new(mocks.Kinesis), nil, nil, nil, nil, nil, nil, 0)
+	assert.Error(t, err)
+
+	m, err := NewMulti(new(mocks.Kinesis), nil, nil, nil, []string{"a"}, nil, nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultOptions.MaxShardWorkers, m.Options.MaxShardWorkers)
+}
+
+func TestMultiKinesumerBegin(t *testing.T) {
+	m, kin, _, prov := makeTestMultiKinesumer(t, []string{"x"}, regexp.MustCompile("^[ab]$"))
+
+	var keys []string
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		m.mut.Lock()
+		keys = append(keys, args.String(1))
+		m.mut.Unlock()
+	})
+	kin.On("ListStreams", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	n, err := m.Begin()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+
+	kinesumers := m.Kinesumers()
+	assert.Equal(t, 3, len(kinesumers))
+	assert.Equal(t, "b", kinesumers["b"].Stream)
+
+	// Each stream's shards are locked under keys qualified with the stream's name.
+	m.mut.Lock()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"a:shard0", "a:shard1", "b:shard0", "b:shard1", "x:shard0", "x:shard1"}, keys)
+	m.mut.Unlock()
+	m.End()
+}
+
+func TestMultiKinesumerGetStreamsByARN(t *testing.T) {
+	arn := "arn:aws:kinesis:us-east-1:123456789012:stream/a"
+	m, kin, _, _ := makeTestMultiKinesumer(t, []string{arn}, regexp.MustCompile("^[ab]$"))
+
+	kin.On("ListStreams", mock.Anything, mock.Anything).Return(nil)
+
+	streams, err := m.GetStreams(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{arn, "b"}, streams)
+}
+
+func TestMultiKinesumerRunListStreamsFailure(t *testing.T) {
+	m, kin, sssm, _ := makeTestMultiKinesumer(t, nil, regexp.MustCompile("^[ab]$"))
+
+	kin.On("ListStreams", mock.Anything, mock.Anything).Return(apiError("LimitExceededException", "slow down"))
+
+	// The checkpointer isn't ended, since it was never begun.
+	assert.Error(t, m.Run(context.Background()))
+	sssm.AssertNotCalled(t, "Begin", mock.Anything)
+	sssm.AssertNotCalled(t, "End")
+}
+
+func TestMultiKinesumerWorkerLimit(t *testing.T) {
+	m, kin, _, prov := makeTestMultiKinesumer(t, []string{"x", "y"}, nil)
+	m.Options.MaxShardWorkers = 3
+
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	n, err := m.Begin()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, m.shardsOwned(0))
+	m.End()
+	assert.Equal(t, 0, m.shardsOwned(0))
+}
+
+func TestMultiKinesumerRecords(t *testing.T) {
+	m, kin, _, prov := makeTestMultiKinesumer(t, []string{"x"}, nil)
+
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records: []types.Record{{
+			Data:           []byte("hello"),
+			PartitionKey:   aws.String("a"),
+			SequenceNumber: aws.String("1"),
+		}},
+	}, nil).Once()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	_, err := m.Begin()
+	assert.Nil(t, err)
+
+	record := <-m.Records()
+	assert.Equal(t, "x", record.StreamName())
+	assert.Equal(t, k.ShardKey("x", record.ShardId()), k.CheckpointKey(record))
+	m.End()
+}
+
+func TestMultiKinesumerRunEnd(t *testing.T) {
+	m, kin, sssm, prov := makeTestMultiKinesumer(t, []string{"x"}, nil)
+	m.Options.ShardStreams = true
+
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil)
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, m.Run(ctx))
+
+	// Run has already ended the MultiKinesumer, so a deferred End does nothing.
+	m.End()
+	sssm.AssertNumberOfCalls(t, "End", 1)
+}
+
+func TestMultiKinesumerStreamKinesis(t *testing.T) {
+	arn := "arn:aws:kinesis:us-west-2:123456789012:stream/x"
+	m, other, _, prov := makeTestMultiKinesumer(t, []string{arn}, nil)
+	kin := new(mocks.Kinesis)
+	m.StreamKinesis = func(stream string) (k.Kinesis, error) {
+		assert.Equal(t, arn, stream)
+		return other, nil
+	}
+
+	var keys []string
+	prov.On("TryAcquire", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		m.mut.Lock()
+		keys = append(keys, args.String(1))
+		m.mut.Unlock()
+	})
+	var described []string
+	other.On("DescribeStream", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		m.mut.Lock()
+		described = append(described, aws.ToString(args.Get(1).(*kinesis.DescribeStreamInput).StreamARN))
+		m.mut.Unlock()
+	})
+	other.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+	m.Kinesis = kin
+
+	n, err := m.Begin()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	kin.AssertNotCalled(t, "DescribeStream", mock.Anything, mock.Anything)
+
+	// The stream is described and its shards are keyed by ARN.
+	m.mut.Lock()
+	assert.Equal(t, arn, described[0])
+	sort.Strings(keys)
+	assert.Equal(t, []string{arn + ":shard0", arn + ":shard1"}, keys)
+	m.mut.Unlock()
+	m.End()
+}
+
+func TestMultiKinesumerBeginFailure(t *testing.T) {
+	m, kin, _, _ := makeTestMultiKinesumer(t, []string{"x"}, nil)
+
+	kin.On("DescribeStream", mock.Anything, mock.Anything).Return(apiError("ResourceNotFoundException", "gone"))
+
+	_, err := m.Begin()
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(m.Kinesumers()))
+	m.End()
+}
@@ -0,0 +1,190 @@
+package kinesumer
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"time"
+
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// DefaultPoolRetryBackoff is how long a Pool waits before retrying a record that its Processor
+// failed. The wait doubles with each retry, up to maxHandlerRetryBackoff.
+const DefaultPoolRetryBackoff = time.Second
+
+// poolQueueSize is how many records can wait for each of a Pool's goroutines before the records
+// of other shards or keys are held up behind them.
+const poolQueueSize = 100
+
+type PoolOptions struct {
+	// How many records are processed at once. The zero value is runtime.NumCPU().
+	Workers int
+
+	// If ByPartitionKey is set, only records with the same partition key are processed in order,
+	// instead of all of the records of a shard, so that a single busy shard can be spread across
+	// the workers.
+	ByPartitionKey bool
+
+	// How many times a record is retried after the Processor fails before the Pool stops. Zero
+	// doesn't retry and a negative value retries forever.
+	Retries int
+
+	// How long to wait before retrying a failed record. The zero value is
+	// DefaultPoolRetryBackoff.
+	RetryBackoff time.Duration
+
+	// If DeadLetter is set, a record that still fails after Retries is parked in it and marked
+	// done, instead of the Pool stopping.
+	DeadLetter k.DeadLetter
+
+	ErrHandler func(k.Error)
+}
+
+var DefaultPoolOptions = PoolOptions{
+	Retries:      3,
+	RetryBackoff: DefaultPoolRetryBackoff,
+	ErrHandler:   DefaultErrHandler,
+}
+
+// Pool processes records with a fixed number of goroutines while keeping the records of each
+// shard, or of each partition key, in order. Each record is marked done once it has been
+// processed, so a shard's checkpoint never advances past a record that hasn't been.
+type Pool struct {
+	Processor k.Processor
+	Options   *PoolOptions
+}
+
+func NewPool(processor k.Processor, opt *PoolOptions) *Pool {
+	if opt == nil {
+		tmp := DefaultPoolOptions
+		opt = &tmp
+	}
+	if opt.Workers <= 0 {
+		opt.Workers = runtime.NumCPU()
+	}
+	if opt.RetryBackoff == 0 {
+		opt.RetryBackoff = DefaultPoolRetryBackoff
+	}
+	if opt.ErrHandler == nil {
+		opt.ErrHandler = DefaultErrHandler
+	}
+
+	return &Pool{
+		Processor: processor,
+		Options:   opt,
+	}
+}
+
+// Run processes records, such as those from Kinesumer.Records, until the channel is closed or
+// ctx is done, and waits for the records that have been started on to finish. If a record fails
+// more than Retries times and there is no DeadLetter to park it in, Run stops and returns its error
+// without marking it, or any record that hasn't been processed yet, done.
+func (p *Pool) Run(ctx context.Context, records <-chan k.Record) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, p.Options.Workers)
+	queues := make([]chan k.Record, p.Options.Workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan k.Record, poolQueueSize)
+		wg.Add(1)
+		go func(queue <-chan k.Record) {
+			defer wg.Done()
+			for record := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := p.process(ctx, record); err != nil && ctx.Err() == nil {
+					errs <- err
+					cancel()
+				}
+			}
+		}(queues[i])
+	}
+
+loop:
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				break loop
+			}
+			select {
+			case queues[p.queue(record)] <- record:
+			case <-ctx.Done():
+				break loop
+			}
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// queue returns the index of the goroutine that processes the record's shard or partition key.
+func (p *Pool) queue(record k.Record) int {
+	key := record.ShardId()
+	if p.Options.ByPartitionKey {
+		key = record.PartitionKey()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(p.Options.Workers))
+}
+
+// process passes a record to the Processor, retrying with a backoff while it fails, and then marks
+// it done.
+func (p *Pool) process(ctx context.Context, record k.Record) error {
+	backoff := p.Options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.Processor.Process(ctx, record)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fields := []interface{}{"shard", record.ShardId(), "sequence", record.ExtendedSequenceNumber()}
+		if p.Options.Retries >= 0 && attempt >= p.Options.Retries {
+			if p.Options.DeadLetter != nil {
+				if parkErr := p.Options.DeadLetter.Park(ctx, record, err); parkErr != nil {
+					e := NewError(EError, "Could not park record", parkErr).With(fields...)
+					p.Options.ErrHandler(e)
+					return e
+				}
+				p.Options.ErrHandler(NewError(EWarn, "Processor failed, parked record", err).With(fields...))
+				break
+			}
+			e := NewError(EError, "Processor failed", err).With(fields...)
+			p.Options.ErrHandler(e)
+			return e
+		}
+		p.Options.ErrHandler(NewError(EWarn, "Processor failed, retrying record", err).With(fields...).With("attempt", attempt+1))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxHandlerRetryBackoff {
+			backoff = maxHandlerRetryBackoff
+		}
+	}
+
+	record.Done()
+	return nil
+}
@@ -0,0 +1,127 @@
+package kinesumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeTestPoolRecords(shards, n int, checkpointC chan k.Record) chan k.Record {
+	records := make(chan k.Record, shards*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < shards; j++ {
+			records <- &Record{
+				shardId:        fmt.Sprintf("shard%d", j),
+				partitionKey:   fmt.Sprintf("key%d", i%4),
+				sequenceNumber: fmt.Sprintf("%03d", i),
+				checkpointC:    checkpointC,
+			}
+		}
+	}
+	close(records)
+	return records
+}
+
+func TestPoolRunOrdered(t *testing.T) {
+	for _, byPartitionKey := range []bool{false, true} {
+		checkpointC := make(chan k.Record, 300)
+		records := makeTestPoolRecords(3, 100, checkpointC)
+
+		var mut sync.Mutex
+		processed := make(map[string][]string)
+		p := NewPool(ProcessorFunc(func(ctx context.Context, record k.Record) error {
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+			key := record.ShardId()
+			if byPartitionKey {
+				key += "/" + record.PartitionKey()
+			}
+			mut.Lock()
+			defer mut.Unlock()
+			processed[key] = append(processed[key], record.SequenceNumber())
+			return nil
+		}), &PoolOptions{Workers: 4, ByPartitionKey: byPartitionKey})
+
+		assert.Nil(t, p.Run(context.Background(), records))
+		assert.Len(t, checkpointC, 300)
+		for key, sequences := range processed {
+			for i := 1; i < len(sequences); i++ {
+				assert.True(t, sequences[i-1] < sequences[i], "%s processed out of order", key)
+			}
+		}
+	}
+}
+
+func TestPoolRunFailure(t *testing.T) {
+	checkpointC := make(chan k.Record, 10)
+	records := makeTestPoolRecords(1, 10, checkpointC)
+
+	attempts := 0
+	var logged []k.Error
+	p := NewPool(ProcessorFunc(func(ctx context.Context, record k.Record) error {
+		if record.SequenceNumber() == "005" {
+			attempts++
+			return errors.New("bad record")
+		}
+		return nil
+	}), &PoolOptions{
+		Workers:      2,
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+		ErrHandler:   func(err k.Error) { logged = append(logged, err) },
+	})
+
+	err := p.Run(context.Background(), records)
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	assert.Equal(t, 2, attempts)
+	assert.Len(t, logged, 2)
+
+	// The records after the one that failed aren't marked done, since they're processed in order.
+	assert.Len(t, checkpointC, 5)
+}
+
+func TestPoolRunDeadLetter(t *testing.T) {
+	checkpointC := make(chan k.Record, 10)
+	records := makeTestPoolRecords(1, 10, checkpointC)
+
+	deadLetter := new(mocks.DeadLetter)
+	deadLetter.On("Park", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	p := NewPool(ProcessorFunc(func(ctx context.Context, record k.Record) error {
+		if record.SequenceNumber() == "005" {
+			return errors.New("bad record")
+		}
+		return nil
+	}), &PoolOptions{
+		Workers:      2,
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+		DeadLetter:   deadLetter,
+		ErrHandler:   func(k.Error) {},
+	})
+
+	assert.Nil(t, p.Run(context.Background(), records))
+	deadLetter.AssertNumberOfCalls(t, "Park", 1)
+	assert.Equal(t, "005", deadLetter.Calls[0].Arguments.Get(1).(k.Record).SequenceNumber())
+	assert.Len(t, checkpointC, 10)
+}
+
+func TestPoolRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPool(ProcessorFunc(func(ctx context.Context, record k.Record) error {
+		return nil
+	}), nil)
+
+	done := make(chan error)
+	go func() { done <- p.Run(ctx, make(chan k.Record)) }()
+	cancel()
+	assert.Nil(t, <-done)
+}
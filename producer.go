@@ -0,0 +1,242 @@
+package kinesumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	k "github.com/remind101/kinesumer/interface"
+)
+
+const (
+	// According to the Kinesis limits documentation, a PutRecords request can hold up to 500
+	// records and 5 MB, and each record up to 1 MB, counting its data and partition key.
+	//
+	// See http://docs.aws.amazon.com/streams/latest/dev/service-sizes-and-limits.html
+	maxPutRecordsCount = 500
+	maxPutRecordsSize  = 5 << 20
+	maxRecordSize      = 1 << 20
+	maxPartitionKeyLen = 256
+
+	// DefaultFlushInterval is how often a Producer that has begun sends the records it has
+	// buffered.
+	DefaultFlushInterval = time.Second
+
+	// DefaultPutRetryBackoff is how long a Producer waits before retrying the records that
+	// PutRecords failed. The wait doubles with each retry.
+	DefaultPutRetryBackoff = 100 * time.Millisecond
+)
+
+type ProducerOptions struct {
+	// How often buffered records are sent once Begin has been called. The zero value is
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+
+	// How many times records that PutRecords failed, for instance because their shard was
+	// throttled, are retried. Zero doesn't retry and a negative value retries forever.
+	PutRetries int
+
+	// How long to wait before retrying failed records. The zero value is DefaultPutRetryBackoff.
+	PutRetryBackoff time.Duration
+
+	ErrHandler func(k.Error)
+}
+
+var DefaultProducerOptions = ProducerOptions{
+	FlushInterval:   DefaultFlushInterval,
+	PutRetries:      3,
+	PutRetryBackoff: DefaultPutRetryBackoff,
+	ErrHandler:      DefaultErrHandler,
+}
+
+// Producer puts records on a stream. Records are buffered and sent with PutRecords once a full
+// request's worth has been buffered, when Flush is called, or every FlushInterval after Begin.
+// Records that are retried can end up on their shard after records that were put after them.
+type Producer struct {
+	Kinesis k.KinesisPutter
+	Stream  string
+	Options *ProducerOptions
+
+	// mut is held while records are being sent, so that Put blocks while a full buffer is sent.
+	mut     sync.Mutex
+	buf     []types.PutRecordsRequestEntry
+	bufSize int
+
+	cancel  context.CancelFunc
+	flushed chan Unit
+}
+
+func NewProducer(kinesis k.KinesisPutter, stream string, opt *ProducerOptions) (*Producer, error) {
+	if kinesis == nil {
+		return nil, errors.New("Kinesis client must not be nil")
+	}
+	if len(stream) == 0 {
+		return nil, errors.New("Stream name can't be empty")
+	}
+	if opt == nil {
+		tmp := DefaultProducerOptions
+		opt = &tmp
+	}
+	if opt.ErrHandler == nil {
+		opt.ErrHandler = DefaultErrHandler
+	}
+
+	return &Producer{
+		Kinesis: kinesis,
+		Stream:  stream,
+		Options: opt,
+	}, nil
+}
+
+// Put buffers a record to be put on the shard that partitionKey hashes to.
+func (p *Producer) Put(ctx context.Context, data []byte, partitionKey string) error {
+	return p.put(ctx, types.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(partitionKey),
+	})
+}
+
+// PutExplicitHashKey buffers a record to be put on the shard whose hash key range contains
+// explicitHashKey, a decimal 128 bit integer, instead of the hash of partitionKey.
+func (p *Producer) PutExplicitHashKey(ctx context.Context, data []byte, partitionKey, explicitHashKey string) error {
+	return p.put(ctx, types.PutRecordsRequestEntry{
+		Data:            data,
+		ExplicitHashKey: aws.String(explicitHashKey),
+		PartitionKey:    aws.String(partitionKey),
+	})
+}
+
+func (p *Producer) put(ctx context.Context, entry types.PutRecordsRequestEntry) error {
+	keyLen := len(aws.ToString(entry.PartitionKey))
+	if keyLen == 0 || keyLen > maxPartitionKeyLen {
+		return fmt.Errorf("Partition key must be 1 to %d characters long", maxPartitionKeyLen)
+	}
+	size := len(entry.Data) + keyLen
+	if size > maxRecordSize {
+		return fmt.Errorf("Record of %d bytes is over the limit of %d", size, maxRecordSize)
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if len(p.buf) == maxPutRecordsCount || p.bufSize+size > maxPutRecordsSize {
+		if err := p.flush(ctx); err != nil {
+			return err
+		}
+	}
+	p.buf = append(p.buf, entry)
+	p.bufSize += size
+	return nil
+}
+
+// Flush sends the buffered records. If some of them couldn't be put, they are dropped and an
+// *Error is returned.
+func (p *Producer) Flush(ctx context.Context) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.flush(ctx)
+}
+
+func (p *Producer) flush(ctx context.Context) error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	entries := p.buf
+	p.buf = nil
+	p.bufSize = 0
+	return p.send(ctx, entries)
+}
+
+// send puts entries on the stream, retrying the ones that fail.
+func (p *Producer) send(ctx context.Context, entries []types.PutRecordsRequestEntry) error {
+	backoff := p.Options.PutRetryBackoff
+	if backoff == 0 {
+		backoff = DefaultPutRetryBackoff
+	}
+
+	for retries := 0; ; retries++ {
+		name, arn := streamParams(p.Stream)
+		out, err := p.Kinesis.PutRecords(ctx, &kinesis.PutRecordsInput{
+			Records:    entries,
+			StreamARN:  arn,
+			StreamName: name,
+		})
+		if err == nil {
+			if aws.ToInt32(out.FailedRecordCount) == 0 {
+				return nil
+			}
+
+			// Results are in the same order as the records that were put.
+			failed := make([]types.PutRecordsRequestEntry, 0, aws.ToInt32(out.FailedRecordCount))
+			var result types.PutRecordsResultEntry
+			for i, r := range out.Records {
+				if r.ErrorCode != nil && i < len(entries) {
+					failed = append(failed, entries[i])
+					result = r
+				}
+			}
+			if len(failed) == 0 {
+				return nil
+			}
+			entries = failed
+			err = fmt.Errorf("%d records failed, last with %s: %s", len(failed),
+				aws.ToString(result.ErrorCode), aws.ToString(result.ErrorMessage))
+		}
+
+		if p.Options.PutRetries >= 0 && retries >= p.Options.PutRetries {
+			return NewError(EError, fmt.Sprintf("Could not put %d records", len(entries)), err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return NewError(EError, fmt.Sprintf("Could not put %d records", len(entries)), ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// Begin starts sending buffered records every FlushInterval, until End is called.
+func (p *Producer) Begin() {
+	interval := p.Options.FlushInterval
+	if interval == 0 {
+		interval = DefaultFlushInterval
+	}
+
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	p.flushed = make(chan Unit)
+	go func() {
+		defer close(p.flushed)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Records being retried are dropped when End is called, so that End doesn't
+				// wait on a flush that retries forever.
+				if err := p.Flush(ctx); err != nil {
+					p.Options.ErrHandler(NewError(EError, "Flush failed", err))
+				}
+			}
+		}
+	}()
+}
+
+// End stops the periodic sending started by Begin, and sends the records that are still
+// buffered. Records that a periodic send is still retrying are dropped.
+func (p *Producer) End() error {
+	if p.cancel != nil {
+		p.cancel()
+		<-p.flushed
+		p.cancel = nil
+	}
+	return p.Flush(context.Background())
+}
@@ -0,0 +1,183 @@
+package kinesumer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeTestProducer(t *testing.T) (*Producer, *mocks.Kinesis, *[]*kinesis.PutRecordsInput) {
+	kin := new(mocks.Kinesis)
+	p, err := NewProducer(kin, "TestStream", &ProducerOptions{
+		PutRetries:      2,
+		PutRetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []*kinesis.PutRecordsInput{}
+	kin.On("PutRecords", mock.Anything, mock.Anything).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(0),
+	}, nil).Run(func(args mock.Arguments) {
+		inputs = append(inputs, args.Get(1).(*kinesis.PutRecordsInput))
+	})
+	return p, kin, &inputs
+}
+
+func TestProducerPutBatches(t *testing.T) {
+	p, kin, inputs := makeTestProducer(t)
+	ctx := context.Background()
+
+	for i := 0; i < maxPutRecordsCount+1; i++ {
+		assert.NoError(t, p.Put(ctx, []byte("a"), "key"))
+	}
+	// The first request was sent once it was full.
+	kin.AssertNumberOfCalls(t, "PutRecords", 1)
+	assert.Equal(t, maxPutRecordsCount, len((*inputs)[0].Records))
+	assert.Equal(t, "TestStream", aws.ToString((*inputs)[0].StreamName))
+
+	big := bytes.Repeat([]byte("a"), maxRecordSize-3)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, p.PutExplicitHashKey(ctx, big, "key", "0"))
+	}
+	// The request would have gone over 5 MB with the fifth record.
+	kin.AssertNumberOfCalls(t, "PutRecords", 2)
+	assert.Equal(t, 5, len((*inputs)[1].Records))
+
+	assert.NoError(t, p.Flush(ctx))
+	kin.AssertNumberOfCalls(t, "PutRecords", 3)
+	assert.Equal(t, 1, len((*inputs)[2].Records))
+	assert.Equal(t, "0", aws.ToString((*inputs)[2].Records[0].ExplicitHashKey))
+
+	assert.NoError(t, p.Flush(ctx))
+	kin.AssertNumberOfCalls(t, "PutRecords", 3)
+
+	assert.Error(t, p.Put(ctx, []byte("a"), ""))
+	assert.Error(t, p.Put(ctx, append(big, "abc"...), "key"))
+}
+
+func TestProducerRetry(t *testing.T) {
+	kin := new(mocks.Kinesis)
+	p, _ := NewProducer(kin, "TestStream", &ProducerOptions{
+		PutRetries:      1,
+		PutRetryBackoff: time.Millisecond,
+	})
+	ctx := context.Background()
+
+	var retried *kinesis.PutRecordsInput
+	kin.On("PutRecords", mock.Anything, mock.Anything).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(1),
+		Records: []types.PutRecordsResultEntry{
+			{SequenceNumber: aws.String("1"), ShardId: aws.String("shard0")},
+			{ErrorCode: aws.String("ProvisionedThroughputExceededException")},
+			{SequenceNumber: aws.String("2"), ShardId: aws.String("shard0")},
+		},
+	}, nil).Once()
+	kin.On("PutRecords", mock.Anything, mock.Anything).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(0),
+	}, nil).Run(func(args mock.Arguments) {
+		retried = args.Get(1).(*kinesis.PutRecordsInput)
+	}).Once()
+
+	p.Put(ctx, []byte("a"), "key")
+	p.Put(ctx, []byte("b"), "key")
+	p.Put(ctx, []byte("c"), "key")
+	assert.NoError(t, p.Flush(ctx))
+	// Only the failed record was retried.
+	assert.Equal(t, 1, len(retried.Records))
+	assert.Equal(t, []byte("b"), retried.Records[0].Data)
+
+	kin.On("PutRecords", mock.Anything, mock.Anything).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(1),
+		Records: []types.PutRecordsResultEntry{
+			{ErrorCode: aws.String("InternalFailure")},
+		},
+	}, nil)
+	p.Put(ctx, []byte("d"), "key")
+	err := p.Flush(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	kin.AssertNumberOfCalls(t, "PutRecords", 4)
+}
+
+func TestProducerBeginEnd(t *testing.T) {
+	kin := new(mocks.Kinesis)
+	p, err := NewProducer(kin, "TestStream", &ProducerOptions{FlushInterval: time.Millisecond})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	inputs := make(chan *kinesis.PutRecordsInput, 2)
+	kin.On("PutRecords", mock.Anything, mock.Anything).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(0),
+	}, nil).Run(func(args mock.Arguments) {
+		inputs <- args.Get(1).(*kinesis.PutRecordsInput)
+	})
+
+	p.Begin()
+	assert.NoError(t, p.Put(ctx, []byte("a"), "key"))
+	// The first record is flushed by the interval.
+	select {
+	case input := <-inputs:
+		assert.Equal(t, []byte("a"), input.Records[0].Data)
+	case <-time.After(time.Second):
+		t.Fatal("Record was not flushed")
+	}
+	assert.NoError(t, p.Put(ctx, []byte("b"), "key"))
+	assert.NoError(t, p.End())
+
+	kin.AssertNumberOfCalls(t, "PutRecords", 2)
+	assert.Equal(t, []byte("b"), (<-inputs).Records[0].Data)
+}
+
+func TestProducerEndDuringRetries(t *testing.T) {
+	kin := new(mocks.Kinesis)
+	errs := make(chan k.Error, 1)
+	p, err := NewProducer(kin, "TestStream", &ProducerOptions{
+		FlushInterval:   time.Millisecond,
+		PutRetries:      -1,
+		PutRetryBackoff: time.Millisecond,
+		ErrHandler: func(err k.Error) {
+			errs <- err
+		},
+	})
+	assert.NoError(t, err)
+
+	calls := make(chan Unit, 1)
+	kin.On("PutRecords", mock.Anything, mock.Anything).Return(nil, errors.New("throttled")).Run(func(args mock.Arguments) {
+		select {
+		case calls <- Unit{}:
+		default:
+		}
+	})
+
+	p.Begin()
+	assert.NoError(t, p.Put(context.Background(), []byte("a"), "key"))
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("Record was not flushed")
+	}
+
+	ended := make(chan error)
+	go func() {
+		ended <- p.End()
+	}()
+	select {
+	case err := <-ended:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("End waited on a flush that retries forever")
+	}
+	assert.Equal(t, EError, (<-errs).Severity())
+}
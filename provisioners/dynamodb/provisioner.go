@@ -0,0 +1,329 @@
+package dynamodbprovisioner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/pborman/uuid"
+	"github.com/remind101/kinesumer/dynamodbclient"
+	k "github.com/remind101/kinesumer/interface"
+)
+
+const (
+	// DefaultKey is the hash key of the lease table. A Java KCL lease table uses "leaseKey".
+	DefaultKey = "shardId"
+
+	// DefaultTTL is how long a lease has to go without being renewed before other consumers may
+	// take it.
+	DefaultTTL = 10 * time.Second
+)
+
+// Provisioner coordinates shard ownership with leases in a DynamoDB table, laid out like the Java
+// KCL's: each shard's item has a leaseOwner and a leaseCounter that the owner increments each time
+// it renews the lease. Other consumers judge a lease to have expired when they have seen its
+// counter go unchanged for the TTL, so that expiry doesn't depend on the consumers' clocks
+// agreeing.
+type Provisioner struct {
+	db    k.DynamoDB
+	table string
+	key   string
+	owner string
+	ttl   time.Duration
+
+	mut sync.Mutex
+	// held is the leases this consumer holds.
+	held map[string]lease
+	// observed is the leases held by other consumers, as last seen by this one.
+	observed map[string]observation
+}
+
+type lease struct {
+	counter int64
+	renewed time.Time
+}
+
+type observation struct {
+	owner   string
+	counter int64
+	// seen is when the lease was first seen with this owner and counter.
+	seen time.Time
+}
+
+type Options struct {
+	// The zero value is DefaultTTL.
+	TTL time.Duration
+
+	// Owner identifies this consumer in the leaseOwner attribute. The zero value is a random UUID.
+	Owner string
+
+	DynamoDB k.DynamoDB
+	Table    string
+
+	// The zero value is DefaultKey.
+	Key string
+}
+
+func New(opt *Options) (*Provisioner, error) {
+	if opt.DynamoDB == nil {
+		return nil, errors.New("DynamoDB client must not be nil")
+	}
+	if len(opt.Table) == 0 {
+		return nil, errors.New("Table name can't be empty")
+	}
+	if opt.Owner == "" {
+		opt.Owner = uuid.New()
+	}
+	if opt.Key == "" {
+		opt.Key = DefaultKey
+	}
+	if opt.TTL == 0 {
+		opt.TTL = DefaultTTL
+	}
+
+	return &Provisioner{
+		db:       opt.DynamoDB,
+		table:    opt.Table,
+		key:      opt.Key,
+		owner:    opt.Owner,
+		ttl:      opt.TTL,
+		held:     make(map[string]lease),
+		observed: make(map[string]observation),
+	}, nil
+}
+
+// Owner returns the ID this consumer holds leases under. A DynamoDB checkpointer sharing the table
+// can be given it as its LeaseOwner.
+func (p *Provisioner) Owner() string {
+	return p.owner
+}
+
+// TryAcquire takes the shard's lease if it has no owner, or its owner has let it expire.
+func (p *Provisioner) TryAcquire(ctx context.Context, shardID string) error {
+	if len(shardID) == 0 {
+		return errors.New("ShardId cannot be empty")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mut.Lock()
+	_, held := p.held[shardID]
+	p.mut.Unlock()
+	if held {
+		return errors.New("Lease is already held")
+	}
+
+	item, err := p.getLease(ctx, shardID)
+	if err != nil {
+		return err
+	}
+
+	owner := dynamodbclient.StringValue(item["leaseOwner"])
+	if len(owner) > 0 && owner != p.owner && !p.expired(shardID, owner, dynamodbclient.Int64Value(item["leaseCounter"])) {
+		return errors.New("Lease is held by " + owner)
+	}
+	return p.take(ctx, shardID, item)
+}
+
+// Steal takes the shard's lease even if another consumer holds it, to balance shards between
+// consumers. The previous owner finds out that it has lost the lease the next time it renews it.
+func (p *Provisioner) Steal(ctx context.Context, shardID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	item, err := p.getLease(ctx, shardID)
+	if err != nil {
+		return err
+	}
+	return p.take(ctx, shardID, item)
+}
+
+func (p *Provisioner) getLease(ctx context.Context, shardID string) (map[string]*dynamodbclient.AttributeValue, error) {
+	out, err := p.db.GetItem(ctx, &dynamodbclient.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            p.itemKey(shardID),
+		TableName:      aws.String(p.table),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+// expired records the owner and counter of a lease held by another consumer, and returns whether
+// they have gone unchanged for the TTL.
+func (p *Provisioner) expired(shardID, owner string, counter int64) bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	now := time.Now()
+	o, ok := p.observed[shardID]
+	if !ok || o.owner != owner || o.counter != counter {
+		p.observed[shardID] = observation{owner: owner, counter: counter, seen: now}
+		return false
+	}
+	return now.Sub(o.seen) > p.ttl
+}
+
+// take makes this consumer the owner of a lease, as long as its counter hasn't changed since item
+// was read.
+func (p *Provisioner) take(ctx context.Context, shardID string, item map[string]*dynamodbclient.AttributeValue) error {
+	condition := "attribute_not_exists(leaseCounter)"
+	values := map[string]*dynamodbclient.AttributeValue{
+		":owner": dynamodbclient.S(p.owner),
+		":one":   dynamodbclient.N(1),
+	}
+	if counter, ok := item["leaseCounter"]; ok {
+		condition = "leaseCounter = :counter"
+		values[":counter"] = counter
+	}
+
+	out, err := p.db.UpdateItem(ctx, &dynamodbclient.UpdateItemInput{
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+		Key:                       p.itemKey(shardID),
+		ReturnValues:              aws.String("UPDATED_NEW"),
+		TableName:                 aws.String(p.table),
+		UpdateExpression:          aws.String("SET leaseOwner = :owner ADD leaseCounter :one"),
+	})
+	if dynamodbclient.IsConditionalCheckFailed(err) {
+		return errors.New("Lease was taken by another owner")
+	}
+	if err != nil {
+		return err
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.held[shardID] = lease{
+		counter: dynamodbclient.Int64Value(out.Attributes["leaseCounter"]),
+		renewed: time.Now(),
+	}
+	delete(p.observed, shardID)
+	return nil
+}
+
+// Release gives up the lease so that another consumer can take it straight away.
+func (p *Provisioner) Release(ctx context.Context, shardID string) error {
+	p.mut.Lock()
+	delete(p.held, shardID)
+	p.mut.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := p.db.UpdateItem(ctx, &dynamodbclient.UpdateItemInput{
+		ConditionExpression: aws.String("leaseOwner = :owner"),
+		ExpressionAttributeValues: map[string]*dynamodbclient.AttributeValue{
+			":owner": dynamodbclient.S(p.owner),
+			":one":   dynamodbclient.N(1),
+		},
+		Key:              p.itemKey(shardID),
+		TableName:        aws.String(p.table),
+		UpdateExpression: aws.String("REMOVE leaseOwner ADD leaseCounter :one"),
+	})
+	if dynamodbclient.IsConditionalCheckFailed(err) {
+		return errors.New("Bad lock")
+	}
+	return err
+}
+
+// Heartbeat renews the lease by incrementing its counter, at most every third of the TTL. It fails
+// if another consumer has taken the lease. A renewal that fails for another reason is only
+// reported once the lease may have expired.
+func (p *Provisioner) Heartbeat(ctx context.Context, shardID string) error {
+	p.mut.Lock()
+	l, ok := p.held[shardID]
+	p.mut.Unlock()
+	if !ok {
+		return errors.New("Cannot heartbeat on lease not originally acquired")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if 3*time.Since(l.renewed) < p.ttl {
+		return nil
+	}
+
+	out, err := p.db.UpdateItem(ctx, &dynamodbclient.UpdateItemInput{
+		ConditionExpression: aws.String("leaseOwner = :owner AND leaseCounter = :counter"),
+		ExpressionAttributeValues: map[string]*dynamodbclient.AttributeValue{
+			":owner":   dynamodbclient.S(p.owner),
+			":counter": dynamodbclient.N(l.counter),
+			":one":     dynamodbclient.N(1),
+		},
+		Key:              p.itemKey(shardID),
+		ReturnValues:     aws.String("UPDATED_NEW"),
+		TableName:        aws.String(p.table),
+		UpdateExpression: aws.String("ADD leaseCounter :one"),
+	})
+	if dynamodbclient.IsConditionalCheckFailed(err) {
+		p.mut.Lock()
+		delete(p.held, shardID)
+		p.mut.Unlock()
+		return errors.New("Lease on " + shardID + " was taken by another owner")
+	}
+	if err != nil {
+		if ctx.Err() == nil && time.Since(l.renewed) < p.ttl {
+			return nil
+		}
+		return err
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if _, ok := p.held[shardID]; ok {
+		p.held[shardID] = lease{
+			counter: dynamodbclient.Int64Value(out.Attributes["leaseCounter"]),
+			renewed: time.Now(),
+		}
+	}
+	return nil
+}
+
+// Consumers counts the owners of the leases in the table that haven't expired, and this consumer.
+// A consumer that holds no leases isn't counted by the others until it takes one, which it can do
+// with Steal. ttl is ignored, since leases expire after the provisioner's TTL.
+func (p *Provisioner) Consumers(ctx context.Context, ttl time.Duration) (int, error) {
+	owners := map[string]bool{p.owner: true}
+	input := &dynamodbclient.ScanInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(p.table),
+	}
+	for {
+		out, err := p.db.Scan(ctx, input)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, item := range out.Items {
+			owner := dynamodbclient.StringValue(item["leaseOwner"])
+			if len(owner) == 0 || owners[owner] {
+				continue
+			}
+			shardID := dynamodbclient.StringValue(item[p.key])
+			if !p.expired(shardID, owner, dynamodbclient.Int64Value(item["leaseCounter"])) {
+				owners[owner] = true
+			}
+		}
+
+		if len(out.LastEvaluatedKey) == 0 {
+			return len(owners), nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+func (p *Provisioner) TTL() time.Duration {
+	return p.ttl
+}
+
+func (p *Provisioner) itemKey(shardID string) map[string]*dynamodbclient.AttributeValue {
+	return map[string]*dynamodbclient.AttributeValue{p.key: dynamodbclient.S(shardID)}
+}
@@ -0,0 +1,226 @@
+package dynamodbprovisioner
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"github.com/pborman/uuid"
+	"github.com/remind101/kinesumer/dynamodbclient"
+	"github.com/remind101/kinesumer/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var errConditionalCheckFailed = &smithy.GenericAPIError{Code: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+
+func makeProvisioner(ttl time.Duration) (*Provisioner, *mocks.DynamoDB) {
+	db := new(mocks.DynamoDB)
+	p, err := New(&Options{
+		TTL:      ttl,
+		Owner:    "worker1",
+		DynamoDB: db,
+		Table:    "leases",
+	})
+	if err != nil {
+		panic(err)
+	}
+	return p, db
+}
+
+func leaseItem(owner string, counter int64) *dynamodbclient.GetItemOutput {
+	return &dynamodbclient.GetItemOutput{
+		Item: map[string]*dynamodbclient.AttributeValue{
+			"shardId":      dynamodbclient.S("shard0"),
+			"leaseOwner":   dynamodbclient.S(owner),
+			"leaseCounter": dynamodbclient.N(counter),
+		},
+	}
+}
+
+func updated(counter int64) *dynamodbclient.UpdateItemOutput {
+	return &dynamodbclient.UpdateItemOutput{
+		Attributes: map[string]*dynamodbclient.AttributeValue{
+			"leaseCounter": dynamodbclient.N(counter),
+		},
+	}
+}
+
+func TestProvisionerTryAcquire(t *testing.T) {
+	p, db := makeProvisioner(time.Second)
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{}, nil)
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(updated(1), nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	})
+
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"), "Couldn't acquire lease")
+	assert.Equal(t, "attribute_not_exists(leaseCounter)", aws.ToString(input.ConditionExpression))
+	assert.Equal(t, "worker1", dynamodbclient.StringValue(input.ExpressionAttributeValues[":owner"]))
+	assert.Equal(t, int64(1), p.held["shard0"].counter)
+
+	assert.Error(t, p.TryAcquire(context.Background(), "shard0"), "Acquired lease twice")
+}
+
+func TestProvisionerTryAcquireExpired(t *testing.T) {
+	p, db := makeProvisioner(10 * time.Millisecond)
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("GetItem", mock.Anything, mock.Anything).Return(leaseItem("worker2", 5), nil).Once()
+	db.On("GetItem", mock.Anything, mock.Anything).Return(leaseItem("worker2", 6), nil).Once()
+	db.On("GetItem", mock.Anything, mock.Anything).Return(leaseItem("worker2", 6), nil).Once()
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(updated(7), nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	})
+
+	assert.Error(t, p.TryAcquire(context.Background(), "shard0"))
+	time.Sleep(20 * time.Millisecond)
+
+	// The lease was renewed since it was last seen.
+	assert.Error(t, p.TryAcquire(context.Background(), "shard0"))
+	time.Sleep(20 * time.Millisecond)
+
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"))
+	assert.Equal(t, "leaseCounter = :counter", aws.ToString(input.ConditionExpression))
+	assert.Equal(t, int64(6), dynamodbclient.Int64Value(input.ExpressionAttributeValues[":counter"]))
+	assert.Equal(t, int64(7), p.held["shard0"].counter)
+}
+
+func TestProvisionerTryAcquireRace(t *testing.T) {
+	p, db := makeProvisioner(time.Second)
+
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{}, nil)
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(nil, errConditionalCheckFailed)
+
+	assert.Error(t, p.TryAcquire(context.Background(), "shard0"))
+	assert.Equal(t, 0, len(p.held))
+}
+
+func TestProvisionerHeartbeat(t *testing.T) {
+	p, db := makeProvisioner(30 * time.Millisecond)
+
+	assert.Error(t, p.Heartbeat(context.Background(), "shard0"), "Heartbeat on a lease that wasn't acquired")
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{}, nil)
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(updated(1), nil).Once()
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(updated(2), nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	}).Once()
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(nil, errConditionalCheckFailed).Once()
+
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"))
+
+	// The lease was just taken, so it doesn't need renewing yet.
+	assert.NoError(t, p.Heartbeat(context.Background(), "shard0"))
+	db.AssertNumberOfCalls(t, "UpdateItem", 1)
+
+	time.Sleep(15 * time.Millisecond)
+	assert.NoError(t, p.Heartbeat(context.Background(), "shard0"))
+	assert.Equal(t, "leaseOwner = :owner AND leaseCounter = :counter", aws.ToString(input.ConditionExpression))
+	assert.Equal(t, int64(1), dynamodbclient.Int64Value(input.ExpressionAttributeValues[":counter"]))
+	assert.Equal(t, int64(2), p.held["shard0"].counter)
+
+	// Another consumer took the lease.
+	time.Sleep(15 * time.Millisecond)
+	assert.Error(t, p.Heartbeat(context.Background(), "shard0"))
+	assert.Error(t, p.Heartbeat(context.Background(), "shard0"))
+}
+
+func TestProvisionerRelease(t *testing.T) {
+	p, db := makeProvisioner(time.Second)
+
+	var input *dynamodbclient.UpdateItemInput
+	db.On("GetItem", mock.Anything, mock.Anything).Return(&dynamodbclient.GetItemOutput{}, nil)
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(updated(1), nil).Once()
+	db.On("UpdateItem", mock.Anything, mock.Anything).Return(updated(2), nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*dynamodbclient.UpdateItemInput)
+	}).Once()
+
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"))
+	assert.NoError(t, p.Release(context.Background(), "shard0"), "Couldn't release lease")
+	assert.Equal(t, "REMOVE leaseOwner ADD leaseCounter :one", aws.ToString(input.UpdateExpression))
+	assert.Equal(t, 0, len(p.held))
+}
+
+func TestProvisionerConsumers(t *testing.T) {
+	p, db := makeProvisioner(10 * time.Millisecond)
+
+	item := func(shardID, owner string, counter int64) map[string]*dynamodbclient.AttributeValue {
+		return map[string]*dynamodbclient.AttributeValue{
+			"shardId":      dynamodbclient.S(shardID),
+			"leaseOwner":   dynamodbclient.S(owner),
+			"leaseCounter": dynamodbclient.N(counter),
+		}
+	}
+	db.On("Scan", mock.Anything, mock.Anything).Return(&dynamodbclient.ScanOutput{
+		Items:            []map[string]*dynamodbclient.AttributeValue{item("shard0", "worker2", 3), item("shard1", "worker2", 1)},
+		LastEvaluatedKey: map[string]*dynamodbclient.AttributeValue{"shardId": dynamodbclient.S("shard1")},
+	}, nil).Once()
+	db.On("Scan", mock.Anything, mock.Anything).Return(&dynamodbclient.ScanOutput{
+		Items: []map[string]*dynamodbclient.AttributeValue{item("shard2", "worker3", 8), {"shardId": dynamodbclient.S("shard3")}},
+	}, nil).Once()
+
+	n, err := p.Consumers(context.Background(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	db.AssertNumberOfCalls(t, "Scan", 2)
+
+	// worker3 stopped renewing its lease.
+	time.Sleep(20 * time.Millisecond)
+	db.On("Scan", mock.Anything, mock.Anything).Return(&dynamodbclient.ScanOutput{
+		Items: []map[string]*dynamodbclient.AttributeValue{item("shard0", "worker2", 4), item("shard2", "worker3", 8)},
+	}, nil).Once()
+	n, err = p.Consumers(context.Background(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+// TestProvisionerDynamoDBLocal runs against DynamoDB Local when DYNAMODB_ENDPOINT is set, e.g. to
+// http://localhost:8000.
+func TestProvisionerDynamoDBLocal(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT is not set")
+	}
+
+	db := dynamodbclient.New(aws.Config{
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		Region:      "us-east-1",
+	}, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	table := "kinesumer-test-" + uuid.New()
+	assert.NoError(t, dynamodbclient.EnsureTable(context.Background(), db, table, DefaultKey))
+
+	newProvisioner := func(owner string) *Provisioner {
+		p, err := New(&Options{TTL: 300 * time.Millisecond, Owner: owner, DynamoDB: db, Table: table})
+		assert.NoError(t, err)
+		return p
+	}
+	p1, p2 := newProvisioner("worker1"), newProvisioner("worker2")
+	ctx := context.Background()
+
+	assert.NoError(t, p1.TryAcquire(ctx, "shard0"))
+	assert.Error(t, p2.TryAcquire(ctx, "shard0"))
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, p1.Heartbeat(ctx, "shard0"))
+	time.Sleep(200 * time.Millisecond)
+	// The lease was renewed, so it hasn't expired.
+	assert.Error(t, p2.TryAcquire(ctx, "shard0"))
+
+	time.Sleep(400 * time.Millisecond)
+	assert.NoError(t, p2.TryAcquire(ctx, "shard0"))
+	assert.Error(t, p1.Heartbeat(ctx, "shard0"))
+
+	assert.NoError(t, p2.Release(ctx, "shard0"))
+	assert.NoError(t, p1.TryAcquire(ctx, "shard0"))
+	assert.NoError(t, p2.Steal(ctx, "shard0"))
+	assert.Error(t, p1.Release(ctx, "shard0"))
+}
@@ -1,21 +1,22 @@
 package emptyprovisioner
 
 import (
+	"context"
 	"time"
 )
 
 type Provisioner struct {
 }
 
-func (p Provisioner) TryAcquire(shardID string) error {
+func (p Provisioner) TryAcquire(ctx context.Context, shardID string) error {
 	return nil
 }
 
-func (p Provisioner) Release(shardID string) error {
+func (p Provisioner) Release(ctx context.Context, shardID string) error {
 	return nil
 }
 
-func (p Provisioner) Heartbeat(shardID string) error {
+func (p Provisioner) Heartbeat(ctx context.Context, shardID string) error {
 	return nil
 }
 
@@ -1,22 +1,27 @@
 package redisprovisioner
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/pborman/uuid" // Exported from code.google.com/p/go-uuid/uuid
+	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/loggers/empty"
 )
 
 type Provisioner struct {
 	acquired      map[string]bool
+	acquiredMut   sync.RWMutex
 	heartbeats    map[string]time.Time
 	heartbeatsMut sync.RWMutex
 	ttl           time.Duration
 	pool          *redis.Pool
 	redisPrefix   string
 	lock          string
+	logger        k.Logger
 }
 
 type Options struct {
@@ -24,6 +29,9 @@ type Options struct {
 	Lock        string
 	RedisPool   *redis.Pool
 	RedisPrefix string
+
+	// Logger is told when a lock that expired is acquired again. The zero value discards it.
+	Logger k.Logger
 }
 
 func New(opt *Options) (*Provisioner, error) {
@@ -31,6 +39,10 @@ func New(opt *Options) (*Provisioner, error) {
 		opt.Lock = uuid.New()
 	}
 
+	if opt.Logger == nil {
+		opt.Logger = emptylogger.Logger{}
+	}
+
 	return &Provisioner{
 		acquired:    make(map[string]bool),
 		heartbeats:  make(map[string]time.Time),
@@ -38,13 +50,23 @@ func New(opt *Options) (*Provisioner, error) {
 		lock:        opt.Lock,
 		pool:        opt.RedisPool,
 		redisPrefix: opt.RedisPrefix,
+		logger:      opt.Logger,
 	}, nil
 }
 
-func (p *Provisioner) TryAcquire(shardID string) error {
+// Owner returns the value this consumer's locks are set to. A redis checkpointer can be given it as
+// its LeaseOwner.
+func (p *Provisioner) Owner() string {
+	return p.lock
+}
+
+func (p *Provisioner) TryAcquire(ctx context.Context, shardID string) error {
 	if len(shardID) == 0 {
 		return errors.New("ShardId cannot be empty")
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	conn := p.pool.Get()
 	defer conn.Close()
@@ -57,15 +79,25 @@ func (p *Provisioner) TryAcquire(shardID string) error {
 		return errors.New("Failed to acquire lock")
 	}
 
+	p.acquiredMut.Lock()
+	defer p.acquiredMut.Unlock()
 	p.acquired[shardID] = true
 	return nil
 }
 
-func (p *Provisioner) Release(shardID string) error {
+func (p *Provisioner) Release(ctx context.Context, shardID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	conn := p.pool.Get()
 	defer conn.Close()
 
-	delete(p.acquired, shardID)
+	func() {
+		p.acquiredMut.Lock()
+		defer p.acquiredMut.Unlock()
+		delete(p.acquired, shardID)
+	}()
 
 	key := p.redisPrefix + ":lock:" + shardID
 	res, err := redis.String(conn.Do("GET", key))
@@ -90,10 +122,16 @@ func (p *Provisioner) Check(shardID string) (string, error) {
 	return redis.String(conn.Do("GET", p.redisPrefix+":lock:"+shardID))
 }
 
-func (p *Provisioner) Heartbeat(shardID string) error {
-	if !p.acquired[shardID] {
+func (p *Provisioner) Heartbeat(ctx context.Context, shardID string) error {
+	p.acquiredMut.RLock()
+	acquired := p.acquired[shardID]
+	p.acquiredMut.RUnlock()
+	if !acquired {
 		return errors.New("Cannot heartbeat on lock not originally acquired")
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	var (
 		lastHeartbeat time.Time
@@ -128,7 +166,8 @@ func (p *Provisioner) Heartbeat(shardID string) error {
 
 	lock, err := redis.String(res, err)
 	if lock == "" {
-		return p.TryAcquire(shardID)
+		p.logger.Log(k.EWarn, "Lock expired, acquiring it again", "shard", shardID, "lock", p.lock)
+		return p.TryAcquire(ctx, shardID)
 	}
 	if lock != p.lock {
 		return errors.New("Lock changed from " + p.lock + " to " + lock)
@@ -136,7 +175,7 @@ func (p *Provisioner) Heartbeat(shardID string) error {
 
 	res, err = conn.Do("PEXPIRE", lockKey, int64(p.ttl/time.Millisecond))
 	if err != nil {
-		err := p.TryAcquire(shardID)
+		err := p.TryAcquire(ctx, shardID)
 		if err != nil {
 			return err
 		}
@@ -149,6 +188,30 @@ func (p *Provisioner) Heartbeat(shardID string) error {
 	return nil
 }
 
+// Consumers records this consumer in a sorted set of consumers scored by when they expire, drops
+// the ones that have expired and counts the rest.
+func (p *Provisioner) Consumers(ctx context.Context, ttl time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	key := p.redisPrefix + ":consumers"
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	conn.Send("MULTI")
+	conn.Send("ZADD", key, now+int64(ttl/time.Millisecond), p.lock)
+	conn.Send("ZREMRANGEBYSCORE", key, "-inf", now)
+	conn.Send("ZCARD", key)
+	conn.Send("PEXPIRE", key, int64(ttl/time.Millisecond))
+	res, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(res[2], nil)
+}
+
 func (p *Provisioner) TTL() time.Duration {
 	return p.ttl
 }
@@ -1,6 +1,7 @@
 package redisprovisioner
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -34,28 +35,48 @@ func makeProvisioner() *Provisioner {
 
 func TestProvisionerTryAcquire(t *testing.T) {
 	p := makeProvisioner()
-	assert.NoError(t, p.TryAcquire("shard0"), "Couldn't acquire lock")
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"), "Couldn't acquire lock")
 
-	assert.Error(t, p.TryAcquire("shard0"), "Acquired lock")
+	assert.Error(t, p.TryAcquire(context.Background(), "shard0"), "Acquired lock")
 }
 
 func TestProvisionerRelease(t *testing.T) {
 	p := makeProvisioner()
-	assert.NoError(t, p.TryAcquire("shard0"), "Couldn't acquire lock")
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"), "Couldn't acquire lock")
 
-	assert.NoError(t, p.Release("shard0"), "Couldn't release lock")
+	assert.NoError(t, p.Release(context.Background(), "shard0"), "Couldn't release lock")
 
-	assert.NoError(t, p.TryAcquire("shard0"), "Couldn't reacquire lock")
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"), "Couldn't reacquire lock")
 }
 
 func TestProvisionerHeartbeat(t *testing.T) {
 	p := makeProvisioner()
-	err := p.Heartbeat("shard0")
+	err := p.Heartbeat(context.Background(), "shard0")
 	assert.Error(t, err, "managed to heartbeat without acquiring lock")
 
-	assert.NoError(t, p.TryAcquire("shard0"), "Couldn't acquire lock")
+	assert.NoError(t, p.TryAcquire(context.Background(), "shard0"), "Couldn't acquire lock")
 
-	assert.NoError(t, p.Heartbeat("shard0"), "Couldn't heartbeat")
+	assert.NoError(t, p.Heartbeat(context.Background(), "shard0"), "Couldn't heartbeat")
 
 	assert.Equal(t, 1, len(p.heartbeats))
 }
+
+func TestProvisionerConsumers(t *testing.T) {
+	p := makeProvisioner()
+	p.pool.Get().Do("DEL", "testing:consumers")
+
+	n, err := p.Consumers(context.Background(), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	p2 := makeProvisioner()
+	p2.lock = "lock2"
+	n, err = p2.Consumers(context.Background(), 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	time.Sleep(20 * time.Millisecond)
+	n, err = p.Consumers(context.Background(), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -55,8 +55,6 @@ func (r *Reader) Read(b []byte) (n int, err error) {
 			return
 		}
 	}
-
-	return
 }
 
 // copy copies as much as it can from r.buf into b. If it succeeds in copying
@@ -1,6 +1,9 @@
 package kinesumer
 
 import (
+	"sync"
+	"time"
+
 	k "github.com/remind101/kinesumer/interface"
 )
 
@@ -8,9 +11,57 @@ type Record struct {
 	data               []byte
 	partitionKey       string
 	sequenceNumber     string
+	subSequenceNumber  int64
+	aggregated         bool
 	shardId            string
+	key                string
+	streamName         string
 	millisBehindLatest int64
+	arrivalTimestamp   time.Time
+	encryptionType     string
+	explicitHashKey    string
+	leaseEpoch         int64
 	checkpointC        chan<- k.Record
+
+	// pending is the shard worker's count of records which haven't been marked done yet.
+	pending *pendingRecords
+	once    sync.Once
+}
+
+// pendingRecords counts the records that a shard worker has handed out and that haven't been marked
+// done. Unlike a sync.WaitGroup, it can be waited on until a deadline without leaving a goroutine
+// blocked behind records that are never marked done.
+type pendingRecords struct {
+	mut     sync.Mutex
+	n       int
+	drained chan Unit
+}
+
+func (p *pendingRecords) Add(delta int) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.n == 0 {
+		p.drained = make(chan Unit)
+	}
+	p.n += delta
+	if p.n == 0 {
+		close(p.drained)
+	}
+}
+
+func (p *pendingRecords) Done() {
+	p.Add(-1)
+}
+
+// Drained returns a channel that is closed once every record has been marked done.
+func (p *pendingRecords) Drained() <-chan Unit {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.drained == nil {
+		p.drained = make(chan Unit)
+		close(p.drained)
+	}
+	return p.drained
 }
 
 func (r *Record) Data() []byte {
@@ -25,16 +76,71 @@ func (r *Record) SequenceNumber() string {
 	return r.sequenceNumber
 }
 
+func (r *Record) SubSequenceNumber() int64 {
+	return r.subSequenceNumber
+}
+
+func (r *Record) ExtendedSequenceNumber() string {
+	if r.aggregated {
+		return k.JoinSequenceNumber(r.sequenceNumber, r.subSequenceNumber)
+	}
+	return r.sequenceNumber
+}
+
 func (r *Record) ShardId() string {
 	return r.shardId
 }
 
+// CheckpointKey returns the key that the record's shard is checkpointed under.
+func (r *Record) CheckpointKey() string {
+	if len(r.key) > 0 {
+		return r.key
+	}
+	return r.shardId
+}
+
+func (r *Record) StreamName() string {
+	return r.streamName
+}
+
 func (r *Record) MillisBehindLatest() int64 {
 	return r.millisBehindLatest
 }
 
+func (r *Record) ApproximateArrivalTimestamp() time.Time {
+	return r.arrivalTimestamp
+}
+
+func (r *Record) EncryptionType() string {
+	return r.encryptionType
+}
+
+func (r *Record) ExplicitHashKey() string {
+	return r.explicitHashKey
+}
+
+func (r *Record) LeaseEpoch() int64 {
+	return r.leaseEpoch
+}
+
+// Done marks the record as processed, so that its shard can be checkpointed past it. A record
+// marked done after the checkpointer has ended, such as one that took longer than
+// Options.DrainTimeout, isn't checkpointed.
 func (r *Record) Done() {
 	if r.checkpointC != nil {
-		r.checkpointC <- r
+		r.checkpoint()
+	}
+	if r.pending != nil {
+		r.once.Do(r.pending.Done)
 	}
 }
+
+// checkpoint sends the record to the checkpointer. The checkpointer closes DoneC when it ends, and
+// the send panicking then is recovered from rather than allowed to crash a consumer that is
+// shutting down.
+func (r *Record) checkpoint() {
+	defer func() {
+		recover()
+	}()
+	r.checkpointC <- r
+}
@@ -0,0 +1,59 @@
+package kinesumer
+
+import (
+	"errors"
+
+	k "github.com/remind101/kinesumer/interface"
+)
+
+// ErrShardHandedOff is the Err of a ShardStream whose shard was handed off to another consumer to
+// balance the shards between them.
+var ErrShardHandedOff = errors.New("Shard was handed off to another consumer")
+
+// ErrLeaseLost is the Err of a ShardStream whose lease on its shard was lost, after which its
+// records are no longer checkpointed.
+var ErrLeaseLost = errors.New("Lease on shard was lost")
+
+// shardStream is the ShardStream of a shard worker. ended and err are set before c is closed.
+type shardStream struct {
+	streamName string
+	shardID    string
+	c          chan k.Record
+	ended      bool
+	err        error
+}
+
+func (s *shardStream) StreamName() string {
+	return s.streamName
+}
+
+func (s *shardStream) ShardID() string {
+	return s.shardID
+}
+
+func (s *shardStream) Records() <-chan k.Record {
+	return s.c
+}
+
+func (s *shardStream) Ended() bool {
+	return s.ended
+}
+
+func (s *shardStream) Err() error {
+	return s.err
+}
+
+// close records how the worker on the shard stopped and closes the stream's records channel.
+func (s *shardStream) close(worker *ShardWorker, err error) {
+	switch {
+	case worker.ended:
+		s.ended = true
+	case worker.revoked:
+		s.err = ErrLeaseLost
+	case worker.shedding():
+		s.err = ErrShardHandedOff
+	default:
+		s.err = err
+	}
+	close(s.c)
+}
@@ -1,82 +1,165 @@
 package kinesumer
 
 import (
-	"fmt"
+	"context"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/kpl"
+)
+
+const (
+	// How many times getting a shard iterator is attempted before the worker gives up on its
+	// shard, and how long to wait before the first retry.
+	getShardIteratorAttempts = 3
+	getShardIteratorBackoff  = 100 * time.Millisecond
+
+	// Subscriptions are renewed before Kinesis ends them after 5 minutes, and a shard can only be
+	// subscribed to once a second. A worker gives up on its shard after subscribeAttempts
+	// subscriptions fail in a row.
+	subscriptionLifetime = 5 * time.Minute
+	subscribeInterval    = time.Second
+	subscribeAttempts    = 3
+
+	// shardHandOffTimeout bounds how long a worker that is handing its shard off to another
+	// consumer waits for the records it sent to be marked done before releasing the shard.
+	shardHandOffTimeout = 10 * time.Second
 )
 
 type ShardWorker struct {
 	kinesis                k.Kinesis
-	shard                  *kinesis.Shard
+	shard                  types.Shard
 	checkpointer           k.Checkpointer
 	stream                 string
 	pollTime               int
 	sequence               string
-	stop                   <-chan Unit
-	stopped                chan<- Unit
 	c                      chan k.Record
 	provisioner            k.Provisioner
 	errHandler             func(k.Error)
 	defaultIteratorType    string
 	shardIteratorTimestamp time.Time
-	getRecordsThrottle     <-chan time.Time
+	throttle               k.Throttle
 	GetRecordsLimit        int64
+	handler                k.Handler
+	handlerRetries         int
+	handlerRetryBackoff    time.Duration
+	deadLetter             k.DeadLetter
+	fanOut                 k.FanOut
+	consumerARN            string
+	metrics                k.Metrics
+
+	// pending counts the records handed out by this worker that haven't been marked done.
+	pending pendingRecords
+	// ended is set once the shard has been read to its end and checkpointed as such.
+	ended bool
+	// leaseEpoch identifies the lease the worker holds on its shard, and revoked is set once a
+	// heartbeat fails and the lease is lost.
+	leaseEpoch int64
+	revoked    bool
+	// resumeSequence is the aggregated record that the worker resumed part way through, until it
+	// has been read past, and resumeSubSequence the last of its user records that was checkpointed.
+	resumeSequence    string
+	resumeSubSequence int64
+	// cancel stops the worker, and shed is closed first if the shard is being handed off.
+	cancel context.CancelFunc
+	shed   chan Unit
+	// seek is where to start reading the shard instead of its checkpoint.
+	seek *k.StartingPosition
+	// drainTimeout bounds how long a worker that is stopped waits for its records to be marked
+	// done before releasing its shard. A zero value doesn't wait.
+	drainTimeout time.Duration
+	// start is where the worker began reading its shard, until it has read a record.
+	start *k.StartingPosition
+	// key is what the shard is locked, checkpointed and throttled under, if it isn't the shard ID.
+	key string
 }
 
-func (s *ShardWorker) GetShardIterator(iteratorType string, sequence string, timestamp time.Time) (string, error) {
-	var tmp *string
+func (s *ShardWorker) GetShardIterator(ctx context.Context, iteratorType string, sequence string, timestamp time.Time) (string, error) {
+	input := &kinesis.GetShardIteratorInput{
+		ShardId:           s.shard.ShardId,
+		ShardIteratorType: types.ShardIteratorType(iteratorType),
+	}
+	input.StreamName, input.StreamARN = streamParams(s.stream)
 	if len(sequence) > 0 {
-		tmp = &sequence
-	}
-	iter, err := s.kinesis.GetShardIterator(&kinesis.GetShardIteratorInput{
-		ShardId:                s.shard.ShardId,
-		ShardIteratorType:      &iteratorType,
-		StartingSequenceNumber: tmp,
-		StreamName:             &s.stream,
-		Timestamp:              &timestamp,
-	})
+		input.StartingSequenceNumber = &sequence
+	}
+	if !timestamp.IsZero() {
+		input.Timestamp = &timestamp
+	}
+	iter, err := s.kinesis.GetShardIterator(ctx, input)
 	if err != nil {
 		return "", err
 	}
-	return aws.StringValue(iter.ShardIterator), nil
+	return aws.ToString(iter.ShardIterator), nil
 }
 
-func (s *ShardWorker) TryGetShardIterator(iteratorType string, sequence string, timestamp time.Time) string {
-	it, err := s.GetShardIterator(iteratorType, sequence, timestamp)
-	if err != nil {
-		panic(err)
+// TryGetShardIterator gets a shard iterator, retrying failed requests with an exponential backoff.
+func (s *ShardWorker) TryGetShardIterator(ctx context.Context, iteratorType string, sequence string, timestamp time.Time) (string, error) {
+	backoff := getShardIteratorBackoff
+	for attempt := 1; ; attempt++ {
+		it, err := s.GetShardIterator(ctx, iteratorType, sequence, timestamp)
+		if err == nil || attempt == getShardIteratorAttempts {
+			return it, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
 	}
-	return it
 }
 
-func (s *ShardWorker) GetRecords(it string) ([]*kinesis.Record, string, int64, error) {
-	<-s.getRecordsThrottle
+func (s *ShardWorker) GetRecords(ctx context.Context, it string) ([]types.Record, string, int64, error) {
+	key := s.shardKey()
+	if err := s.throttle.Wait(ctx, key); err != nil {
+		return nil, "", 0, err
+	}
 
-	resp, err := s.kinesis.GetRecords(&kinesis.GetRecordsInput{
-		Limit:         &s.GetRecordsLimit,
+	start := time.Now()
+	_, arn := streamParams(s.stream)
+	resp, err := s.kinesis.GetRecords(ctx, &kinesis.GetRecordsInput{
+		Limit:         aws.Int32(int32(s.GetRecordsLimit)),
 		ShardIterator: &it,
+		StreamARN:     arn,
 	})
+	s.metrics.GetRecords(key, time.Since(start), err)
 	if err != nil {
+		// Transient failures back off the same way as throttled requests.
+		class := ClassifyGetRecordsError(err)
+		s.throttle.Observe(key, 0, 0, class == GetRecordsErrorThrottled || class == GetRecordsErrorTransient)
 		return nil, "", 0, err
 	}
-	return resp.Records, aws.StringValue(resp.NextShardIterator), aws.Int64Value(resp.MillisBehindLatest), nil
+	bytes := s.recordsRead(resp.Records, aws.ToInt64(resp.MillisBehindLatest))
+	s.throttle.Observe(key, bytes, aws.ToInt64(resp.MillisBehindLatest), false)
+	return resp.Records, aws.ToString(resp.NextShardIterator), aws.ToInt64(resp.MillisBehindLatest), nil
 }
 
-func (s *ShardWorker) GetRecordsAndProcess(it, sequence string) (cont bool, nextIt string, nextSeq string) {
-	records, nextIt, lag, err := s.GetRecords(it)
+func (s *ShardWorker) GetRecordsAndProcess(ctx context.Context, it, sequence string) (nextIt string, nextSeq string, err error) {
+	records, nextIt, lag, err := s.GetRecords(ctx, it)
 	if err != nil || len(records) == 0 {
 		if err != nil {
-			s.errHandler(NewError(EWarn, "GetRecords failed", err))
-			nextIt = s.TryGetShardIterator("AFTER_SEQUENCE_NUMBER", sequence, time.Time{})
+			if ctx.Err() != nil {
+				return "", sequence, ctx.Err()
+			}
+			var retry bool
+			if nextIt, retry, err = s.recoverGetRecords(ctx, it, sequence, err); err != nil {
+				return "", sequence, err
+			}
+			if retry {
+				if err := s.heartbeat(ctx); err != nil {
+					return "", sequence, err
+				}
+				return nextIt, sequence, nil
+			}
 		}
 
-		if err := s.provisioner.Heartbeat(aws.StringValue(s.shard.ShardId)); err != nil {
-			s.errHandler(NewError(EError, "Heartbeat failed", err))
-			return true, "", sequence
+		if err := s.heartbeat(ctx); err != nil {
+			return "", sequence, err
 		}
 		// GetRecords is not guaranteed to return records even if there are records to be read.
 		// However, if our lag time behind the shard head is <= 3 seconds then there's probably
@@ -84,77 +167,523 @@ func (s *ShardWorker) GetRecordsAndProcess(it, sequence string) (cont bool, next
 		if lag <= 3000 /* milliseconds */ {
 			select {
 			case <-time.NewTimer(time.Duration(s.pollTime) * time.Millisecond).C:
-			case <-s.stop:
-				return true, "", sequence
+			case <-ctx.Done():
+				return "", sequence, ctx.Err()
 			}
 		}
 	} else {
-		for _, rec := range records {
-			s.c <- &Record{
-				data:               rec.Data,
-				partitionKey:       aws.StringValue(rec.PartitionKey),
-				sequenceNumber:     aws.StringValue(rec.SequenceNumber),
-				shardId:            aws.StringValue(s.shard.ShardId),
-				millisBehindLatest: lag,
-				checkpointC:        s.checkpointer.DoneC(),
+		if err := s.processRecords(ctx, records, lag); err != nil {
+			return "", sequence, err
+		}
+		sequence = aws.ToString(records[len(records)-1].SequenceNumber)
+		s.start = nil
+	}
+	return nextIt, sequence, nil
+}
+
+// recoverGetRecords returns the iterator to carry on reading the shard with after GetRecords
+// failed, or an error if the worker has to stop. retry is set if the iterator should be used
+// again without waiting to poll, since the throttle backs off before it is.
+func (s *ShardWorker) recoverGetRecords(ctx context.Context, it, sequence string, err error) (nextIt string, retry bool, _ error) {
+	switch ClassifyGetRecordsError(err) {
+	case GetRecordsErrorThrottled:
+		// The iterator is still good, and the throttle backs off before it's used again.
+		s.errHandler(s.newError(EDebug, "GetRecords throttled", err).With("sequence", sequence))
+		return it, true, nil
+	case GetRecordsErrorTransient:
+		s.errHandler(s.newError(EInfo, "GetRecords failed, retrying", err).With("sequence", sequence))
+		return it, true, nil
+	case GetRecordsErrorKMS:
+		return "", false, s.newError(EError, "Could not decrypt records", err).With("sequence", sequence)
+	case GetRecordsErrorResourceNotFound:
+		return "", false, s.newError(EError, "Stream or shard not found", err).With("sequence", sequence)
+	case GetRecordsErrorExpiredIterator:
+		s.errHandler(s.newError(EInfo, "Shard iterator expired", err).With("sequence", sequence))
+	default:
+		s.errHandler(s.newError(EWarn, "GetRecords failed", err).With("sequence", sequence))
+	}
+
+	position := s.resumePosition(sequence)
+	nextIt, err = s.TryGetShardIterator(ctx, position.Type, position.SequenceNumber, position.Timestamp)
+	if err != nil {
+		return "", false, s.newError(EError, "Could not get shard iterator", err).With("sequence", sequence)
+	}
+	return nextIt, false, nil
+}
+
+// resumePosition returns where a new iterator carries on reading the shard from: after the record
+// with sequence, or where the worker started if it hasn't read a record yet, so that the first
+// record of the shard isn't skipped.
+func (s *ShardWorker) resumePosition(sequence string) k.StartingPosition {
+	if s.start != nil {
+		return *s.start
+	}
+	return k.StartingPosition{Type: s.iteratorType(sequence), SequenceNumber: sequence}
+}
+
+// processRecords passes records to the handler, or sends them on the worker's channel to be
+// checkpointed as they are marked done.
+func (s *ShardWorker) processRecords(ctx context.Context, records []types.Record, lag int64) error {
+	if s.handler != nil {
+		return s.handleBatch(ctx, records, lag)
+	}
+
+	for _, rec := range records {
+		for _, record := range s.newRecords(rec, lag) {
+			record.pending = &s.pending
+			s.pending.Add(1)
+			s.checkpointer.Track(record)
+			select {
+			case s.c <- record:
+			case <-ctx.Done():
+				// A record that was never handed out isn't waited for when the shard is released.
+				// It stays tracked, so that the checkpoint can't move past it.
+				s.pending.Done()
+				return ctx.Err()
 			}
 
-			if err := s.provisioner.Heartbeat(aws.StringValue(s.shard.ShardId)); err != nil {
-				s.errHandler(NewError(EError, "Heartbeat failed", err))
-				return true, "", sequence
+			if err := s.heartbeat(ctx); err != nil {
+				return err
 			}
 		}
-		sequence = aws.StringValue(records[len(records)-1].SequenceNumber)
 	}
-	return false, nextIt, sequence
+	return nil
 }
 
-func (s *ShardWorker) RunWorker() {
-	defer func() {
-		if val := recover(); val != nil {
-			msg := fmt.Sprintf("%v", val)
-			s.errHandler(NewError(ECrit, msg, nil))
+// newRecords returns the records to hand out for a record read from the shard: the user records
+// aggregated into it by the Kinesis Producer Library, or the record itself if it wasn't
+// aggregated. Records at or before the checkpoint that the worker resumed from are left out.
+func (s *ShardWorker) newRecords(rec types.Record, lag int64) []*Record {
+	sequenceNumber := aws.ToString(rec.SequenceNumber)
+	resuming := len(s.resumeSequence) > 0 && sequenceNumber == s.resumeSequence
+	if len(s.resumeSequence) > 0 && !resuming {
+		s.resumeSequence = ""
+	}
+
+	if !kpl.IsAggregated(rec.Data) {
+		if resuming {
+			return nil
+		}
+		return []*Record{s.newRecord(rec, lag)}
+	}
+
+	userRecords, err := kpl.Deaggregate(rec.Data)
+	if err != nil {
+		s.errHandler(s.newError(EWarn, "Could not deaggregate record", err).With("sequence", sequenceNumber))
+		if resuming {
+			return nil
 		}
-	}()
-	defer func() {
-		s.provisioner.Release(aws.StringValue(s.shard.ShardId))
-		s.stopped <- Unit{}
-	}()
+		return []*Record{s.newRecord(rec, lag)}
+	}
 
-	sequence := s.checkpointer.GetStartSequence(aws.StringValue(s.shard.ShardId))
-	end := s.shard.SequenceNumberRange.EndingSequenceNumber
-	var it string
+	records := make([]*Record, 0, len(userRecords))
+	for i, userRecord := range userRecords {
+		if resuming && int64(i) <= s.resumeSubSequence {
+			continue
+		}
+		record := s.newRecord(rec, lag)
+		record.data = userRecord.Data
+		record.partitionKey = userRecord.PartitionKey
+		record.explicitHashKey = userRecord.ExplicitHashKey
+		record.subSequenceNumber = int64(i)
+		record.aggregated = true
+		records = append(records, record)
+	}
+	return records
+}
+
+func (s *ShardWorker) newRecord(rec types.Record, lag int64) *Record {
+	return &Record{
+		data:               rec.Data,
+		partitionKey:       aws.ToString(rec.PartitionKey),
+		sequenceNumber:     aws.ToString(rec.SequenceNumber),
+		shardId:            aws.ToString(s.shard.ShardId),
+		key:                s.key,
+		streamName:         s.stream,
+		millisBehindLatest: lag,
+		arrivalTimestamp:   aws.ToTime(rec.ApproximateArrivalTimestamp),
+		encryptionType:     string(rec.EncryptionType),
+		leaseEpoch:         s.leaseEpoch,
+		checkpointC:        s.checkpointer.DoneC(),
+	}
+}
+
+// iteratorType returns the type of shard iterator that carries on reading the shard after the
+// record with sequence. A record that the worker resumed part way through is read again.
+func (s *ShardWorker) iteratorType(sequence string) string {
+	if len(s.resumeSequence) > 0 && sequence == s.resumeSequence {
+		return "AT_SEQUENCE_NUMBER"
+	}
+	return "AFTER_SEQUENCE_NUMBER"
+}
+
+// handleBatch passes records to the handler, retrying with a backoff while it fails, and then
+// checkpoints the last record of the batch. If the retries run out and there's a dead letter sink,
+// the records are passed to the handler one at a time and only those that still fail are parked.
+func (s *ShardWorker) handleBatch(ctx context.Context, records []types.Record, lag int64) error {
+	batch := make([]k.Record, 0, len(records))
+	for _, rec := range records {
+		for _, record := range s.newRecords(rec, lag) {
+			batch = append(batch, record)
+		}
+	}
+	if len(batch) == 0 {
+		return s.heartbeat(ctx)
+	}
+
+	failed, err := s.handle(ctx, batch)
+	if err != nil {
+		return err
+	}
+	if failed != nil {
+		if s.deadLetter == nil {
+			return s.newError(EError, "Handler failed", failed).With("sequence", batch[0].SequenceNumber())
+		}
+		if err := s.parkFailed(ctx, batch, failed); err != nil {
+			return err
+		}
+	}
+
+	batch[len(batch)-1].Done()
+	return s.heartbeat(ctx)
+}
+
+// handle passes records to the handler, retrying with a backoff while it fails. It returns the
+// handler's last error as failed once the retries run out, and an error if the worker should stop.
+func (s *ShardWorker) handle(ctx context.Context, records []k.Record) (failed error, err error) {
+	backoff := s.handlerRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := s.handler.HandleBatch(ctx, aws.ToString(s.shard.ShardId), records)
+		if err == nil {
+			return nil, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if s.handlerRetries >= 0 && attempt >= s.handlerRetries {
+			return err, nil
+		}
+		s.errHandler(s.newError(EWarn, "Handler failed, retrying", err).
+			With("sequence", records[0].ExtendedSequenceNumber(), "records", len(records), "attempt", attempt+1))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > maxHandlerRetryBackoff {
+			backoff = maxHandlerRetryBackoff
+		}
+
+		if err := s.heartbeat(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parkFailed finds the records of a batch that the handler kept failing by passing them to it one
+// at a time, and parks those, so that one bad record doesn't keep the rest of the batch from being
+// processed.
+func (s *ShardWorker) parkFailed(ctx context.Context, batch []k.Record, reason error) error {
+	if len(batch) == 1 {
+		return s.park(ctx, batch[0], reason)
+	}
+
+	for _, record := range batch {
+		failed, err := s.handle(ctx, []k.Record{record})
+		if err != nil {
+			return err
+		}
+		if failed != nil {
+			if err := s.park(ctx, record, failed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// park sends a record that the handler kept failing to the dead letter sink, so that the shard can
+// carry on past it.
+func (s *ShardWorker) park(ctx context.Context, record k.Record, reason error) error {
+	if err := s.deadLetter.Park(ctx, record, reason); err != nil {
+		return s.newError(EError, "Could not park record", err).With("sequence", record.ExtendedSequenceNumber())
+	}
+	s.errHandler(s.newError(EWarn, "Handler failed, parked record", reason).With("sequence", record.ExtendedSequenceNumber()))
+	return nil
+}
+
+// recordsRead tells the metrics about a batch of records read from the shard, and returns how many
+// bytes of data they held.
+func (s *ShardWorker) recordsRead(records []types.Record, lag int64) int {
+	bytes := 0
+	for _, rec := range records {
+		bytes += len(rec.Data)
+	}
+	s.metrics.RecordsRead(s.shardKey(), len(records), bytes, lag)
+	return bytes
+}
+
+// newError returns an error with fields naming the worker's stream and shard and, if the
+// provisioner has one, the ID that its lock on the shard is held under.
+func (s *ShardWorker) newError(severity, message string, origin error) *Error {
+	err := NewError(severity, message, origin).With("stream", s.stream, "shard", aws.ToString(s.shard.ShardId))
+	if owner, ok := s.provisioner.(k.Owner); ok {
+		err.With("lock", owner.Owner())
+	}
+	return err
+}
+
+// shardKey returns the key that the worker's shard is locked and checkpointed under.
+func (s *ShardWorker) shardKey() string {
+	if len(s.key) > 0 {
+		return s.key
+	}
+	return aws.ToString(s.shard.ShardId)
+}
+
+// heartbeat renews the worker's lock on its shard. If it fails, the lease is treated as lost.
+func (s *ShardWorker) heartbeat(ctx context.Context) error {
+	err := s.provisioner.Heartbeat(ctx, s.shardKey())
+	s.metrics.Heartbeat(s.shardKey(), err)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.revoked = true
+		}
+		return s.newError(EError, "Heartbeat failed", err).With("epoch", s.leaseEpoch)
+	}
+	return nil
+}
+
+// RunWorker reads records from the shard until ctx is done, the shard ends or the worker fails.
+// The worker's lock on the shard is released when it returns, so that the shard can be retried.
+func (s *ShardWorker) RunWorker(ctx context.Context) error {
+	defer s.release(ctx)
+
+	if s.seek != nil {
+		return s.runFrom(ctx, *s.seek)
+	}
+
+	sequence, err := s.checkpointer.GetStartSequence(ctx, s.shardKey())
+	if err != nil {
+		return s.newError(EError, "Could not get checkpoint", err)
+	}
+	if sequence == k.ShardEnd {
+		s.ended = true
+		return nil
+	}
+
+	// A checkpoint part way through an aggregated record is resumed from by reading the record
+	// again and skipping the user records up to the checkpoint.
+	if sequenceNumber, subSequenceNumber, aggregated := k.SplitSequenceNumber(sequence); aggregated {
+		sequence = sequenceNumber
+		s.resumeSequence, s.resumeSubSequence = sequenceNumber, subSequenceNumber
+	}
+
+	position := k.StartingPosition{Type: s.iteratorType(sequence), SequenceNumber: sequence}
 	if len(sequence) == 0 {
-		sequence = aws.StringValue(s.shard.SequenceNumberRange.StartingSequenceNumber)
+		s.errHandler(s.newError(EWarn, "Using "+s.defaultIteratorType, nil))
+		position = k.StartingPosition{Type: s.defaultIteratorType, Timestamp: s.shardIteratorTimestamp}
+	}
+	return s.runFrom(ctx, position)
+}
 
-		s.errHandler(NewError(EWarn, "Using "+s.defaultIteratorType, nil))
-		it = s.TryGetShardIterator(s.defaultIteratorType, "", s.shardIteratorTimestamp)
-	} else {
-		it = s.TryGetShardIterator("AFTER_SEQUENCE_NUMBER", sequence, time.Time{})
+// runFrom reads records from position in the shard until ctx is done, the shard ends or the worker
+// fails.
+func (s *ShardWorker) runFrom(ctx context.Context, position k.StartingPosition) error {
+	if s.fanOut != nil {
+		return s.runSubscriptions(ctx, position)
 	}
 
-loop:
-	for {
+	// sequence is the last record read, or the start of the shard if the position has none.
+	sequence := position.SequenceNumber
+	if len(sequence) == 0 {
+		sequence = aws.ToString(s.shard.SequenceNumberRange.StartingSequenceNumber)
+	}
+
+	end := s.shard.SequenceNumberRange.EndingSequenceNumber
+	start := position
+	if position.Type == "LATEST" {
+		// The tip of the shard moves on, so a new iterator is got from the time this one was,
+		// reading again any records that arrived around then rather than missing those since.
+		start = k.StartingPosition{Type: "AT_TIMESTAMP", Timestamp: time.Now()}
+	}
+	it, err := s.TryGetShardIterator(ctx, position.Type, position.SequenceNumber, position.Timestamp)
+	if err != nil {
+		return s.newError(EError, "Could not get shard iterator", err).With("sequence", sequence)
+	}
+	s.start = &start
+
+	for ctx.Err() == nil {
 		if len(it) == 0 || end != nil && sequence == *end {
-			s.errHandler(NewError(EWarn, "Shard has reached its end", nil))
-			break loop
+			s.errHandler(s.newError(EWarn, "Shard has reached its end", nil))
+			s.checkpointShardEnd(ctx)
+			return nil
+		}
+
+		if err := s.heartbeat(ctx); err != nil {
+			return err
+		}
+
+		if it, sequence, err = s.GetRecordsAndProcess(ctx, it, sequence); err != nil {
+			return err
 		}
+	}
+	return ctx.Err()
+}
 
-		if err := s.provisioner.Heartbeat(aws.StringValue(s.shard.ShardId)); err != nil {
-			s.errHandler(NewError(EError, "Heartbeat failed", err))
-			break loop
+// runSubscriptions reads the shard with enhanced fan-out until ctx is done, the shard ends or the
+// worker fails, subscribing again from where the last subscription left off each time one ends.
+func (s *ShardWorker) runSubscriptions(ctx context.Context, position k.StartingPosition) error {
+	failures := 0
+	for ctx.Err() == nil {
+		subscribed := time.Now()
+		sub, subErr := s.fanOut.SubscribeToShard(ctx, s.consumerARN, aws.ToString(s.shard.ShardId), position)
+		if subErr == nil {
+			var (
+				ended bool
+				err   error
+			)
+			ended, subErr, err = s.consumeSubscription(ctx, sub, &position)
+			if err != nil {
+				return err
+			}
+			if ended {
+				s.errHandler(s.newError(EWarn, "Shard has reached its end", nil))
+				s.checkpointShardEnd(ctx)
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			break
 		}
 
+		if subErr != nil {
+			if failures++; failures == subscribeAttempts {
+				return s.newError(EError, "Could not subscribe to shard", subErr)
+			}
+			s.errHandler(s.newError(EWarn, "Subscription failed, resubscribing", subErr))
+		} else {
+			failures = 0
+		}
+
+		if err := s.heartbeat(ctx); err != nil {
+			return err
+		}
 		select {
-		case <-s.stop:
-			break loop
-		default:
-			if brk, nextIt, seq := s.GetRecordsAndProcess(it, sequence); brk {
-				break loop
-			} else {
-				it = nextIt
-				sequence = seq
+		case <-time.After(subscribeInterval - time.Since(subscribed)):
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+// consumeSubscription processes the events pushed on a subscription, and advances position past
+// each one, until the subscription ends or is due to be renewed. ended is set if the shard has
+// been read to its end. subErr is the error that ended the subscription, and err is set if the
+// records could not be processed.
+func (s *ShardWorker) consumeSubscription(ctx context.Context, sub k.ShardSubscription, position *k.StartingPosition) (ended bool, subErr error, err error) {
+	defer sub.Close()
+
+	renew := time.NewTimer(subscriptionLifetime)
+	defer renew.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return false, sub.Err(), nil
+			}
+			s.recordsRead(event.Records, event.MillisBehindLatest)
+			if len(event.Records) > 0 {
+				if err := s.processRecords(ctx, event.Records, event.MillisBehindLatest); err != nil {
+					return false, nil, err
+				}
+			}
+			if len(event.ContinuationSequenceNumber) == 0 {
+				return true, nil, nil
+			}
+			*position = k.StartingPosition{Type: "AFTER_SEQUENCE_NUMBER", SequenceNumber: event.ContinuationSequenceNumber}
+
+			if err := s.heartbeat(ctx); err != nil {
+				return false, nil, err
 			}
+		case <-renew.C:
+			return false, nil, nil
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		}
+	}
+}
+
+// Shed stops the worker so that its shard can be taken by another consumer.
+func (s *ShardWorker) Shed() {
+	if !s.shedding() {
+		close(s.shed)
+		s.cancel()
+	}
+}
+
+func (s *ShardWorker) shedding() bool {
+	select {
+	case <-s.shed:
+		return true
+	default:
+		return false
+	}
+}
+
+// release releases the worker's lock on its shard. A worker that is handing its shard off, or
+// being stopped with a drain timeout, first waits for its records to be marked done and saves the
+// checkpoints, so that the next owner of the shard carries on from where this one stopped.
+func (s *ShardWorker) release(ctx context.Context) {
+	var timeout time.Duration
+	switch {
+	case s.revoked:
+		// The shard is no longer ours to checkpoint.
+	case s.shedding():
+		timeout = shardHandOffTimeout
+	case ctx.Err() != nil:
+		timeout = s.drainTimeout
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	if timeout > 0 {
+		drainCtx, cancel := context.WithTimeout(ctx, timeout)
+		s.waitPending(drainCtx)
+		cancel()
+		s.checkpointer.Sync()
+	}
+	s.provisioner.Release(ctx, s.shardKey())
+	s.metrics.ShardReleased(s.shardKey())
+	s.throttle.ShardReleased(s.shardKey())
+}
+
+// waitPending waits for every record handed out by the worker to be marked done, and returns
+// whether they were before ctx was done.
+func (s *ShardWorker) waitPending(ctx context.Context) bool {
+	select {
+	case <-s.pending.Drained():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checkpointShardEnd waits for every record handed out by the worker to be marked done, and then
+// checkpoints the shard as ended so that its children can be started.
+func (s *ShardWorker) checkpointShardEnd(ctx context.Context) {
+	if doneC := s.checkpointer.DoneC(); doneC != nil {
+		if !s.waitPending(ctx) {
+			return
+		}
+
+		doneC <- &Record{
+			shardId:        aws.ToString(s.shard.ShardId),
+			key:            s.key,
+			sequenceNumber: k.ShardEnd,
+			leaseEpoch:     s.leaseEpoch,
 		}
 	}
+	s.ended = true
 }
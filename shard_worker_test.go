@@ -1,90 +1,96 @@
 package kinesumer
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	k "github.com/remind101/kinesumer/interface"
+	"github.com/remind101/kinesumer/kpl"
+	"github.com/remind101/kinesumer/metrics/empty"
 	"github.com/remind101/kinesumer/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 func makeTestShardWorker() (*ShardWorker, *mocks.Kinesis, *mocks.Checkpointer, *mocks.Provisioner,
-	chan Unit, chan Unit, chan k.Record) {
+	chan k.Record) {
 	kin := new(mocks.Kinesis)
 	sssm := new(mocks.Checkpointer)
 	prov := new(mocks.Provisioner)
-	stop := make(chan Unit, 1)
-	stopped := make(chan Unit, 1)
 	c := make(chan k.Record, 100)
 
-	// No throttling in tests
-	getRecordsThrottle := make(chan time.Time)
-	close(getRecordsThrottle)
-
 	return &ShardWorker{
 		kinesis: kin,
-		shard: &kinesis.Shard{
+		shard: types.Shard{
 			AdjacentParentShardId: nil,
-			HashKeyRange: &kinesis.HashKeyRange{
+			HashKeyRange: &types.HashKeyRange{
 				StartingHashKey: aws.String("0"),
 				EndingHashKey:   aws.String("7f"),
 			},
 			ParentShardId: nil,
-			SequenceNumberRange: &kinesis.SequenceNumberRange{
+			SequenceNumberRange: &types.SequenceNumberRange{
 				StartingSequenceNumber: aws.String("0"),
 				EndingSequenceNumber:   aws.String("100"),
 			},
 			ShardId: aws.String("shard0"),
 		},
-		checkpointer:       sssm,
-		stream:             "TestStream",
-		sequence:           "123",
-		stop:               stop,
-		stopped:            stopped,
-		c:                  c,
-		provisioner:        prov,
-		errHandler:         DefaultErrHandler,
-		getRecordsThrottle: getRecordsThrottle,
-		GetRecordsLimit:    123,
-	}, kin, sssm, prov, stop, stopped, c
+		checkpointer:    sssm,
+		stream:          "TestStream",
+		pollTime:        1000,
+		sequence:        "123",
+		c:               c,
+		provisioner:     prov,
+		errHandler:      DefaultErrHandler,
+		metrics:         emptymetrics.Metrics{},
+		throttle:        noThrottle{},
+		GetRecordsLimit: 123,
+	}, kin, sssm, prov, c
 }
 
+// noThrottle lets every GetRecords call through.
+type noThrottle struct{}
+
+func (noThrottle) Wait(ctx context.Context, shardID string) error { return ctx.Err() }
+
+func (noThrottle) Observe(string, int, int64, bool) {}
+
+func (noThrottle) ShardReleased(string) {}
+
 func TestShardWorkerGetShardIterator(t *testing.T) {
-	s, kin, _, _, _, _, _ := makeTestShardWorker()
+	s, kin, _, _, _ := makeTestShardWorker()
 
-	kin.On("GetShardIterator", mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
 		ShardIterator: aws.String("AAAAA"),
-	}, awserr.Error(nil))
-	res, err := s.GetShardIterator("TYPE", "123", time.Time{})
+	}, nil)
+	res, err := s.GetShardIterator(context.Background(), "TYPE", "123", time.Time{})
 	assert.Nil(t, err)
 	assert.Equal(t, "AAAAA", res)
 }
 
 func TestShardWorkerTryGetShardIterator(t *testing.T) {
-	s, kin, _, _, _, _, _ := makeTestShardWorker()
+	s, kin, _, _, _ := makeTestShardWorker()
 
-	kin.On("GetShardIterator", mock.Anything).Return(nil, awserr.New("bad", "bad", errors.New("bad")))
-	assert.Panics(t, func() {
-		s.TryGetShardIterator("TYPE", "123", time.Time{})
-	})
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(nil, apiError("bad", "bad"))
+	_, err := s.TryGetShardIterator(context.Background(), "TYPE", "123", time.Time{})
+	assert.Error(t, err)
+	kin.AssertNumberOfCalls(t, "GetShardIterator", getShardIteratorAttempts)
 }
 
 func TestShardWorkerGetRecords(t *testing.T) {
-	s, kin, _, _, _, _, _ := makeTestShardWorker()
+	s, kin, _, _, _ := makeTestShardWorker()
 
-	kin.On("GetRecords", mock.Anything).Return(&kinesis.GetRecordsOutput{
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
 		MillisBehindLatest: aws.Int64(0),
 		NextShardIterator:  aws.String("AAAA"),
-		Records:            []*kinesis.Record{},
-	}, awserr.Error(nil))
+		Records:            []types.Record{},
+	}, nil)
 
-	records, nextIt, mills, err := s.GetRecords("AAAA")
+	records, nextIt, mills, err := s.GetRecords(context.Background(), "AAAA")
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(records))
 	assert.Equal(t, "AAAA", nextIt)
@@ -92,77 +98,717 @@ func TestShardWorkerGetRecords(t *testing.T) {
 }
 
 func TestShardWorkerGetRecordsAndProcess(t *testing.T) {
-	s, kin, sssm, prov, stp, _, c := makeTestShardWorker()
+	s, kin, sssm, prov, c := makeTestShardWorker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	prov.On("Heartbeat", mock.Anything).Return(nil)
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
 
-	record1 := kinesis.Record{
+	record1 := types.Record{
 		Data:           []byte("help I'm trapped"),
 		PartitionKey:   aws.String("aaaa"),
 		SequenceNumber: aws.String("123"),
 	}
-	kin.On("GetRecords", mock.Anything).Return(&kinesis.GetRecordsOutput{
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
 		MillisBehindLatest: aws.Int64(0),
 		NextShardIterator:  aws.String("AAAA"),
-		Records:            []*kinesis.Record{&record1},
-	}, awserr.Error(nil)).Once()
+		Records:            []types.Record{record1},
+	}, nil).Once()
 	doneC := make(chan k.Record)
 	sssm.On("DoneC").Return(doneC)
-	brk, nextIt, nextSeq := s.GetRecordsAndProcess("AAAA", "123")
+	sssm.On("Track", mock.Anything).Return()
+	nextIt, nextSeq, err := s.GetRecordsAndProcess(ctx, "AAAA", "123")
 	rec := <-c
 	assert.Equal(t, record1.Data, rec.Data())
-	assert.False(t, brk)
+	assert.Nil(t, err)
 	assert.Equal(t, "AAAA", nextIt)
 	assert.Equal(t, "123", nextSeq)
 
-	err := awserr.New("bad", "bad", nil)
-	stp <- Unit{}
-	kin.On("GetRecords", mock.Anything).Return(&kinesis.GetRecordsOutput{
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
 		MillisBehindLatest: aws.Int64(0),
 		NextShardIterator:  aws.String("AAAA"),
-		Records:            []*kinesis.Record{},
-	}, err)
-	kin.On("GetShardIterator", mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		Records:            []types.Record{},
+	}, apiError("bad", "bad"))
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
 		ShardIterator: aws.String("AAAA"),
-	}, awserr.Error(nil))
-	brk, nextIt, nextSeq = s.GetRecordsAndProcess("AAAA", "123")
+	}, nil).Run(func(mock.Arguments) {
+		cancel()
+	})
+	nextIt, nextSeq, err = s.GetRecordsAndProcess(ctx, "AAAA", "123")
 	kin.AssertNumberOfCalls(t, "GetShardIterator", 1)
-	assert.True(t, brk)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestShardWorkerGetRecordsAndProcessMetrics(t *testing.T) {
+	s, kin, sssm, prov, c := makeTestShardWorker()
+	metrics := new(mocks.Metrics)
+	s.metrics = metrics
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	metrics.On("GetRecords", "shard0", mock.Anything, nil).Return()
+	metrics.On("RecordsRead", "shard0", 2, 7, int64(4000)).Return()
+	metrics.On("Heartbeat", "shard0", nil).Return()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(4000),
+		NextShardIterator:  aws.String("AAAA"),
+		Records: []types.Record{
+			{Data: []byte("abc"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("124")},
+			{Data: []byte("defg"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("125")},
+		},
+	}, nil)
+	sssm.On("DoneC").Return(make(chan k.Record))
+	sssm.On("Track", mock.Anything).Return()
+
+	_, _, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(c))
+	metrics.AssertExpectations(t)
+}
+
+func TestShardWorkerGetRecordsAndProcessHeartbeatFailure(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(errors.New("Lock changed"))
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+	sssm.On("DoneC").Return(make(chan k.Record))
+	sssm.On("Track", mock.Anything).Return()
+
+	_, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	assert.Equal(t, "123", nextSeq)
+	assert.True(t, s.revoked)
+}
+
+func TestShardWorkerGetRecordsFailureFields(t *testing.T) {
+	s, kin, _, prov, _ := makeTestShardWorker()
+	var logged []k.Error
+	s.errHandler = func(err k.Error) { logged = append(logged, err) }
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, errors.New("throttled"))
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("BBBB"),
+	}, nil)
+
+	nextIt, _, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, "BBBB", nextIt)
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, []interface{}{"stream", "TestStream", "shard", "shard0", "sequence", "123"},
+			logged[0].(k.FieldsError).Fields())
+	}
+}
+
+func TestShardWorkerGetRecordsThrottled(t *testing.T) {
+	s, kin, _, prov, _ := makeTestShardWorker()
+	throttle := NewAdaptiveThrottle(&ThrottleOptions{Interval: time.Millisecond})
+	s.throttle = throttle
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, apiError("ProvisionedThroughputExceededException", "slow down"))
+
+	nextIt, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, "AAAA", nextIt)
+	assert.Equal(t, "123", nextSeq)
+	kin.AssertNotCalled(t, "GetShardIterator", mock.Anything)
+	assert.Equal(t, 400*time.Millisecond, throttle.shards["shard0"].interval)
+}
+
+func TestShardWorkerGetRecordsExpiredIterator(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+	s.start = &k.StartingPosition{Type: "TRIM_HORIZON"}
+	s.pollTime = 1
+	var inputs []*kinesis.GetShardIteratorInput
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("Track", mock.Anything).Return()
+	sssm.On("DoneC").Return(make(chan k.Record))
+	expired := apiError("ExpiredIteratorException", "expired")
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, expired).Once()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(5000),
+		NextShardIterator:  aws.String("CCCC"),
+		Records:            []types.Record{{Data: []byte("a"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("7")}},
+	}, nil).Once()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, expired).Once()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("BBBB"),
+	}, nil).Run(func(args mock.Arguments) {
+		inputs = append(inputs, args.Get(1).(*kinesis.GetShardIteratorInput))
+	})
+
+	// Nothing has been read yet, so the shard is read again from where the worker started rather
+	// than after its first record.
+	nextIt, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "0")
+	assert.Nil(t, err)
+	assert.Equal(t, "BBBB", nextIt)
+	assert.Equal(t, "0", nextSeq)
+
+	nextIt, nextSeq, err = s.GetRecordsAndProcess(context.Background(), nextIt, nextSeq)
+	assert.Nil(t, err)
+	assert.Equal(t, "CCCC", nextIt)
+	assert.Equal(t, "7", nextSeq)
+
+	nextIt, nextSeq, err = s.GetRecordsAndProcess(context.Background(), nextIt, nextSeq)
+	assert.Nil(t, err)
+	assert.Equal(t, "BBBB", nextIt)
+
+	if assert.Len(t, inputs, 2) {
+		assert.Equal(t, "TRIM_HORIZON", string(inputs[0].ShardIteratorType))
+		assert.Nil(t, inputs[0].StartingSequenceNumber)
+		assert.Equal(t, "AFTER_SEQUENCE_NUMBER", string(inputs[1].ShardIteratorType))
+		assert.Equal(t, "7", aws.ToString(inputs[1].StartingSequenceNumber))
+	}
+}
+
+func TestShardWorkerGetRecordsKMSFailure(t *testing.T) {
+	s, kin, _, _, _ := makeTestShardWorker()
+
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, apiError("KMSAccessDeniedException", "denied"))
+
+	_, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	assert.Equal(t, "123", nextSeq)
+	kin.AssertNotCalled(t, "GetShardIterator", mock.Anything)
+}
+
+func TestShardWorkerRunLatestExpired(t *testing.T) {
+	s, kin, _, prov, _ := makeTestShardWorker()
+	s.pollTime = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	var inputs []*kinesis.GetShardIteratorInput
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, apiError("ExpiredIteratorException", "expired")).Once()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(nil, errors.New("stop")).Run(func(mock.Arguments) { cancel() })
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("AAAA"),
+	}, nil).Run(func(args mock.Arguments) {
+		inputs = append(inputs, args.Get(1).(*kinesis.GetShardIteratorInput))
+	})
+
+	before := time.Now()
+	assert.Equal(t, context.Canceled, s.runFrom(ctx, k.StartingPosition{Type: "LATEST"}))
+	if assert.Len(t, inputs, 2) {
+		assert.Equal(t, "LATEST", string(inputs[0].ShardIteratorType))
+		// The tip of the shard has moved on, so the records since the first iterator was got are
+		// read by timestamp.
+		assert.Equal(t, "AT_TIMESTAMP", string(inputs[1].ShardIteratorType))
+		assert.False(t, inputs[1].Timestamp.Before(before))
+	}
 }
 
 func TestShardWorkerRun(t *testing.T) {
-	s, kin, sssm, prov, stp, stpd, c := makeTestShardWorker()
+	s, kin, sssm, prov, c := makeTestShardWorker()
+	ctx, cancel := context.WithCancel(context.Background())
 
-	prov.On("Heartbeat", mock.Anything).Return(nil)
-	prov.On("Release", mock.Anything).Return(nil)
-	sssm.On("GetStartSequence", mock.Anything).Return("AAAA")
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("AAAA", nil)
 
-	record1 := kinesis.Record{
+	record1 := types.Record{
 		Data:           []byte("help I'm trapped"),
 		PartitionKey:   aws.String("aaaa"),
 		SequenceNumber: aws.String("123"),
 	}
-	kin.On("GetRecords", mock.Anything).Return(&kinesis.GetRecordsOutput{
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{record1},
+	}, nil).Once()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records:            []types.Record{},
+	}, nil)
+	doneC := make(chan k.Record)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("AAAA"),
+	}, nil)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	assert.Equal(t, context.Canceled, s.RunWorker(ctx))
+	prov.AssertCalled(t, "Release", mock.Anything, "shard0")
+	rec := <-c
+	assert.Equal(t, record1.Data, rec.Data())
+}
+
+func TestShardWorkerRunAggregated(t *testing.T) {
+	s, kin, sssm, prov, c := makeTestShardWorker()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	// The first user record of 123 was checkpointed.
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("123:0", nil)
+
+	aggregated := types.Record{
+		Data: kpl.Aggregate([]*kpl.UserRecord{
+			{PartitionKey: "a", Data: []byte("one")},
+			{PartitionKey: "b", Data: []byte("two")},
+			{PartitionKey: "c", Data: []byte("three"), ExplicitHashKey: "42"},
+		}),
+		PartitionKey:                aws.String("a"),
+		SequenceNumber:              aws.String("123"),
+		ApproximateArrivalTimestamp: aws.Time(time.Unix(1500000000, 0)),
+	}
+	plain := types.Record{
+		Data:           []byte("four"),
+		PartitionKey:   aws.String("d"),
+		SequenceNumber: aws.String("124"),
+	}
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
 		MillisBehindLatest: aws.Int64(0),
 		NextShardIterator:  aws.String("AAAA"),
-		Records:            []*kinesis.Record{&record1},
-	}, awserr.Error(nil)).Once()
-	kin.On("GetRecords", mock.Anything).Return(&kinesis.GetRecordsOutput{
+		Records:            []types.Record{aggregated, plain},
+	}, nil).Once()
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
 		MillisBehindLatest: aws.Int64(0),
 		NextShardIterator:  aws.String("AAAA"),
-		Records:            []*kinesis.Record{},
-	}, awserr.Error(nil))
+		Records:            []types.Record{},
+	}, nil)
 	doneC := make(chan k.Record)
 	sssm.On("DoneC").Return(doneC)
-	kin.On("GetShardIterator", mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+	sssm.On("Track", mock.Anything).Return()
+	var input *kinesis.GetShardIteratorInput
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
 		ShardIterator: aws.String("AAAA"),
-	}, awserr.Error(nil))
+	}, nil).Run(func(args mock.Arguments) {
+		input = args.Get(1).(*kinesis.GetShardIteratorInput)
+	})
 	go func() {
 		time.Sleep(10 * time.Millisecond)
-		stp <- Unit{}
+		cancel()
+	}()
+	assert.Equal(t, context.Canceled, s.RunWorker(ctx))
+
+	// The aggregated record is read again, skipping the user record that was checkpointed.
+	assert.Equal(t, "AT_SEQUENCE_NUMBER", string(input.ShardIteratorType))
+	assert.Equal(t, "123", aws.ToString(input.StartingSequenceNumber))
+	rec := <-c
+	assert.Equal(t, "two", string(rec.Data()))
+	assert.Equal(t, "b", rec.PartitionKey())
+	assert.Equal(t, "123", rec.SequenceNumber())
+	assert.Equal(t, int64(1), rec.SubSequenceNumber())
+	assert.Equal(t, "123:1", rec.ExtendedSequenceNumber())
+	assert.Equal(t, "TestStream", rec.StreamName())
+	assert.Equal(t, time.Unix(1500000000, 0), rec.ApproximateArrivalTimestamp())
+	assert.Equal(t, "", rec.ExplicitHashKey())
+	assert.Equal(t, "", rec.EncryptionType())
+	rec = <-c
+	assert.Equal(t, "123:2", rec.ExtendedSequenceNumber())
+	assert.Equal(t, "42", rec.ExplicitHashKey())
+	rec = <-c
+	assert.Equal(t, "four", string(rec.Data()))
+	assert.Equal(t, "124", rec.ExtendedSequenceNumber())
+}
+
+func TestShardWorkerReleaseDrain(t *testing.T) {
+	s, _, sssm, prov, _ := makeTestShardWorker()
+	s.drainTimeout = time.Second
+
+	var calls []string
+	sssm.On("Sync").Return().Run(func(mock.Arguments) { calls = append(calls, "Sync") })
+	prov.On("Release", mock.Anything, "shard0").Return(nil).Run(func(mock.Arguments) { calls = append(calls, "Release") })
+
+	record := &Record{pending: &s.pending}
+	s.pending.Add(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	released := make(chan Unit)
+	go func() {
+		s.release(ctx)
+		close(released)
+	}()
+
+	select {
+	case <-released:
+		t.Fatal("Shard was released before its records were done")
+	case <-time.After(10 * time.Millisecond):
+	}
+	record.Done()
+	<-released
+	assert.Equal(t, []string{"Sync", "Release"}, calls)
+}
+
+func TestShardWorkerReleaseUnsentRecord(t *testing.T) {
+	s, _, sssm, prov, _ := makeTestShardWorker()
+	s.c = make(chan k.Record)
+	s.drainTimeout = time.Hour
+
+	sssm.On("Track", mock.Anything).Return()
+	sssm.On("DoneC").Return(make(chan k.Record))
+	sssm.On("Sync").Return()
+	prov.On("Release", mock.Anything, "shard0").Return(nil)
+
+	// The worker is cancelled while it's blocked handing out a record that nothing receives.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	records := []types.Record{{Data: []byte("a"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("124")}}
+	assert.Equal(t, context.DeadlineExceeded, s.processRecords(ctx, records, 0))
+
+	released := make(chan Unit)
+	go func() {
+		s.release(ctx)
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("Shard release waited for a record that was never handed out")
+	}
+	prov.AssertCalled(t, "Release", mock.Anything, "shard0")
+}
+
+func TestRecordDoneAfterCheckpointerEnd(t *testing.T) {
+	s, _, _, _, _ := makeTestShardWorker()
+	checkpointC := make(chan k.Record)
+	record := &Record{checkpointC: checkpointC, pending: &s.pending}
+	s.pending.Add(1)
+
+	// The checkpointer closes DoneC when it ends.
+	close(checkpointC)
+	assert.NotPanics(t, record.Done)
+	assert.True(t, s.waitPending(context.Background()))
+}
+
+func TestShardWorkerReleaseRevoked(t *testing.T) {
+	s, _, sssm, prov, _ := makeTestShardWorker()
+	s.drainTimeout = time.Second
+	s.revoked = true
+	s.pending.Add(1)
+
+	prov.On("Release", mock.Anything, "shard0").Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.release(ctx)
+	sssm.AssertNotCalled(t, "Sync")
+}
+
+func TestShardWorkerRunShardEnd(t *testing.T) {
+	s, kin, sssm, prov, c := makeTestShardWorker()
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("99", nil)
+
+	record1 := types.Record{
+		Data:           []byte("help I'm trapped"),
+		PartitionKey:   aws.String("aaaa"),
+		SequenceNumber: aws.String("100"),
+	}
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(&kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String("AAAA"),
+	}, nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		Records:            []types.Record{record1},
+	}, nil).Once()
+	doneC := make(chan k.Record, 2)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+
+	errC := make(chan error)
+	go func() {
+		errC <- s.RunWorker(context.Background())
 	}()
-	s.RunWorker()
-	<-stpd
+
+	rec := <-c
+	assertNotCheckpointed(t, doneC)
+	rec.Done()
+	assert.Nil(t, <-errC)
+	assert.True(t, s.ended)
+	assert.Equal(t, "100", (<-doneC).SequenceNumber())
+	assert.Equal(t, k.ShardEnd, (<-doneC).SequenceNumber())
+}
+
+func TestShardWorkerRunEndedShard(t *testing.T) {
+	s, _, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return(k.ShardEnd, nil)
+
+	assert.Nil(t, s.RunWorker(context.Background()))
+	assert.True(t, s.ended)
+}
+
+func TestShardWorkerRunReleaseMetrics(t *testing.T) {
+	s, _, sssm, prov, _ := makeTestShardWorker()
+	metrics := new(mocks.Metrics)
+	s.metrics = metrics
+
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return(k.ShardEnd, nil)
+	metrics.On("ShardReleased", "shard0").Return()
+
+	assert.Nil(t, s.RunWorker(context.Background()))
+	metrics.AssertCalled(t, "ShardReleased", "shard0")
+}
+
+func TestShardWorkerRunFailure(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	kin.On("GetShardIterator", mock.Anything, mock.Anything).Return(nil, apiError("bad", "bad"))
+
+	err := s.RunWorker(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	prov.AssertCalled(t, "Release", mock.Anything, "shard0")
+}
+
+func TestShardWorkerRunCheckpointFailure(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", errors.New("throttled"))
+
+	// The shard isn't read from the default iterator type when its checkpoint can't be read.
+	err := s.RunWorker(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	kin.AssertNotCalled(t, "GetShardIterator", mock.Anything, mock.Anything)
+	prov.AssertCalled(t, "Release", mock.Anything, "shard0")
+}
+
+func TestShardWorkerGetRecordsAndProcessHandler(t *testing.T) {
+	s, kin, sssm, prov, c := makeTestShardWorker()
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records: []types.Record{
+			{Data: []byte("a"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("124")},
+			{Data: []byte("b"), PartitionKey: aws.String("b"), SequenceNumber: aws.String("125")},
+		},
+	}, nil)
+	doneC := make(chan k.Record, 1)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+
+	calls := 0
+	s.handlerRetries = 1
+	s.handler = HandlerFunc(func(ctx context.Context, shardID string, records []k.Record) error {
+		calls++
+		assert.Equal(t, "shard0", shardID)
+		assert.Equal(t, 2, len(records))
+		assertNotCheckpointed(t, doneC)
+		if calls == 1 {
+			return errors.New("bad")
+		}
+		return nil
+	})
+
+	nextIt, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "AAAA", nextIt)
+	assert.Equal(t, "125", nextSeq)
+	assert.Equal(t, "125", (<-doneC).SequenceNumber())
+	assert.Equal(t, 0, len(c))
+}
+
+func TestShardWorkerGetRecordsAndProcessHandlerFailure(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records: []types.Record{
+			{Data: []byte("a"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("124")},
+		},
+	}, nil)
+	doneC := make(chan k.Record, 1)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+
+	calls := 0
+	s.handlerRetries = 2
+	s.handler = HandlerFunc(func(context.Context, string, []k.Record) error {
+		calls++
+		return errors.New("bad")
+	})
+
+	_, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "123", nextSeq)
+	assertNotCheckpointed(t, doneC)
+}
+
+func TestShardWorkerGetRecordsAndProcessHandlerDeadLetter(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records: []types.Record{
+			{Data: []byte("a"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("124")},
+			{Data: []byte("b"), PartitionKey: aws.String("b"), SequenceNumber: aws.String("125")},
+		},
+	}, nil)
+	doneC := make(chan k.Record, 1)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+
+	deadLetter := new(mocks.DeadLetter)
+	deadLetter.On("Park", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	s.deadLetter = deadLetter
+	s.handlerRetries = 1
+	s.handler = HandlerFunc(func(context.Context, string, []k.Record) error {
+		return errors.New("bad")
+	})
+
+	_, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, "125", nextSeq)
+	deadLetter.AssertNumberOfCalls(t, "Park", 2)
+	assert.Equal(t, "bad", deadLetter.Calls[0].Arguments.Error(2).Error())
+	assert.Equal(t, "125", (<-doneC).SequenceNumber())
+}
+
+func TestShardWorkerGetRecordsAndProcessHandlerDeadLetterPoisonRecord(t *testing.T) {
+	s, kin, sssm, prov, _ := makeTestShardWorker()
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	kin.On("GetRecords", mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("AAAA"),
+		Records: []types.Record{
+			{Data: []byte("a"), PartitionKey: aws.String("a"), SequenceNumber: aws.String("124")},
+			{Data: []byte("bad"), PartitionKey: aws.String("b"), SequenceNumber: aws.String("125")},
+			{Data: []byte("c"), PartitionKey: aws.String("c"), SequenceNumber: aws.String("126")},
+		},
+	}, nil)
+	doneC := make(chan k.Record, 1)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+
+	deadLetter := new(mocks.DeadLetter)
+	deadLetter.On("Park", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	s.deadLetter = deadLetter
+	s.handlerRetries = 1
+	handled := make([]string, 0)
+	s.handler = HandlerFunc(func(_ context.Context, _ string, records []k.Record) error {
+		for _, record := range records {
+			if string(record.Data()) == "bad" {
+				return errors.New("bad")
+			}
+		}
+		for _, record := range records {
+			handled = append(handled, record.SequenceNumber())
+		}
+		return nil
+	})
+
+	_, nextSeq, err := s.GetRecordsAndProcess(context.Background(), "AAAA", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, "126", nextSeq)
+	assert.Equal(t, []string{"124", "126"}, handled)
+	deadLetter.AssertNumberOfCalls(t, "Park", 1)
+	assert.Equal(t, "125", deadLetter.Calls[0].Arguments.Get(1).(k.Record).SequenceNumber())
+	assert.Equal(t, "126", (<-doneC).SequenceNumber())
+}
+
+type testSubscription struct {
+	events chan *k.SubscribeToShardEvent
+	err    error
+}
+
+// newTestSubscription returns a subscription that pushes events and then ends with err.
+func newTestSubscription(err error, events ...*k.SubscribeToShardEvent) *testSubscription {
+	c := make(chan *k.SubscribeToShardEvent, len(events))
+	for _, event := range events {
+		c <- event
+	}
+	close(c)
+	return &testSubscription{events: c, err: err}
+}
+
+func (s *testSubscription) Events() <-chan *k.SubscribeToShardEvent { return s.events }
+func (s *testSubscription) Err() error                              { return s.err }
+func (s *testSubscription) Close() error                            { return nil }
+
+func TestShardWorkerRunFanOut(t *testing.T) {
+	s, _, sssm, prov, c := makeTestShardWorker()
+	fanOut := new(mocks.FanOut)
+	s.fanOut = fanOut
+	s.consumerARN = "arn:consumer"
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("98", nil)
+	doneC := make(chan k.Record, 2)
+	sssm.On("DoneC").Return(doneC)
+	sssm.On("Track", mock.Anything).Return()
+
+	record1 := types.Record{
+		Data:           []byte("help I'm trapped"),
+		PartitionKey:   aws.String("aaaa"),
+		SequenceNumber: aws.String("99"),
+		EncryptionType: types.EncryptionTypeKms,
+	}
+	fanOut.On("SubscribeToShard", mock.Anything, "arn:consumer", "shard0", k.StartingPosition{
+		Type:           "AFTER_SEQUENCE_NUMBER",
+		SequenceNumber: "98",
+	}).Return(newTestSubscription(nil, &k.SubscribeToShardEvent{
+		Records:                    []types.Record{record1},
+		ContinuationSequenceNumber: "99",
+	}), nil).Once()
+	fanOut.On("SubscribeToShard", mock.Anything, "arn:consumer", "shard0", k.StartingPosition{
+		Type:           "AFTER_SEQUENCE_NUMBER",
+		SequenceNumber: "99",
+	}).Return(newTestSubscription(nil, &k.SubscribeToShardEvent{}), nil).Once()
+
+	errC := make(chan error)
+	go func() {
+		errC <- s.RunWorker(context.Background())
+	}()
+
 	rec := <-c
 	assert.Equal(t, record1.Data, rec.Data())
+	assert.Equal(t, "KMS", rec.EncryptionType())
+	rec.Done()
+	assert.Nil(t, <-errC)
+	assert.True(t, s.ended)
+	assert.Equal(t, "99", (<-doneC).SequenceNumber())
+	assert.Equal(t, k.ShardEnd, (<-doneC).SequenceNumber())
+	fanOut.AssertNumberOfCalls(t, "SubscribeToShard", 2)
+}
+
+func TestShardWorkerRunFanOutFailure(t *testing.T) {
+	s, _, sssm, prov, _ := makeTestShardWorker()
+	fanOut := new(mocks.FanOut)
+	s.fanOut = fanOut
+	s.defaultIteratorType = "LATEST"
+
+	prov.On("Heartbeat", mock.Anything, mock.Anything).Return(nil)
+	prov.On("Release", mock.Anything, mock.Anything).Return(nil)
+	sssm.On("GetStartSequence", mock.Anything, mock.Anything).Return("", nil)
+	fanOut.On("SubscribeToShard", mock.Anything, mock.Anything, "shard0", k.StartingPosition{
+		Type: "LATEST",
+	}).Return(newTestSubscription(apiError("InternalFailure", "bad")), nil)
+
+	err := s.RunWorker(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, EError, err.(*Error).Severity())
+	fanOut.AssertNumberOfCalls(t, "SubscribeToShard", subscribeAttempts)
+	prov.AssertCalled(t, "Release", mock.Anything, "shard0")
 }
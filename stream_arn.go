@@ -0,0 +1,65 @@
+package kinesumer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// StreamARN is the ARN of a Kinesis stream, arn:<partition>:kinesis:<region>:<account>:stream/<name>.
+type StreamARN struct {
+	Partition string
+	Region    string
+	AccountID string
+	Name      string
+}
+
+// IsStreamARN returns whether stream is addressed by ARN rather than by name. Stream names can't
+// contain colons, so anything starting with "arn:" is taken to be an ARN.
+func IsStreamARN(stream string) bool {
+	return strings.HasPrefix(stream, "arn:")
+}
+
+// ParseStreamARN parses the ARN of a Kinesis stream.
+func ParseStreamARN(arn string) (StreamARN, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "kinesis" {
+		return StreamARN{}, fmt.Errorf("%q is not a Kinesis ARN", arn)
+	}
+	if !strings.HasPrefix(parts[5], "stream/") {
+		return StreamARN{}, fmt.Errorf("%q is not the ARN of a stream", arn)
+	}
+	a := StreamARN{
+		Partition: parts[1],
+		Region:    parts[3],
+		AccountID: parts[4],
+		Name:      strings.TrimPrefix(parts[5], "stream/"),
+	}
+	if a.Partition == "" || a.Region == "" || a.AccountID == "" || a.Name == "" || strings.Contains(a.Name, "/") {
+		return StreamARN{}, fmt.Errorf("%q is not the ARN of a stream", arn)
+	}
+	return a, nil
+}
+
+func (a StreamARN) String() string {
+	return fmt.Sprintf("arn:%s:kinesis:%s:%s:stream/%s", a.Partition, a.Region, a.AccountID, a.Name)
+}
+
+// streamName returns the name of stream, which is either a name or a valid ARN.
+func streamName(stream string) string {
+	if !IsStreamARN(stream) {
+		return stream
+	}
+	arn, _ := ParseStreamARN(stream)
+	return arn.Name
+}
+
+// streamParams returns the StreamName and StreamARN that requests address stream by. A stream
+// given by ARN is addressed by it, which is how streams shared from other accounts are read.
+func streamParams(stream string) (name, arn *string) {
+	if IsStreamARN(stream) {
+		return nil, aws.String(stream)
+	}
+	return aws.String(stream), nil
+}
@@ -0,0 +1,30 @@
+package kinesumer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStreamARN(t *testing.T) {
+	arn, err := ParseStreamARN("arn:aws:kinesis:us-west-2:123456789012:stream/events")
+	assert.Nil(t, err)
+	assert.Equal(t, StreamARN{Partition: "aws", Region: "us-west-2", AccountID: "123456789012", Name: "events"}, arn)
+	assert.Equal(t, "arn:aws:kinesis:us-west-2:123456789012:stream/events", arn.String())
+
+	for _, bad := range []string{
+		"events",
+		"arn:aws:sqs:us-west-2:123456789012:events",
+		"arn:aws:kinesis:us-west-2:123456789012:table/events",
+		"arn:aws:kinesis::123456789012:stream/events",
+		"arn:aws:kinesis:us-west-2:123456789012:stream/events/consumer/c:1",
+	} {
+		_, err := ParseStreamARN(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestStreamName(t *testing.T) {
+	assert.Equal(t, "events", streamName("events"))
+	assert.Equal(t, "events", streamName("arn:aws:kinesis:us-west-2:123456789012:stream/events"))
+}
@@ -0,0 +1,162 @@
+package kinesumer
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// According to the Kinesis limits documentation, each shard supports up to 5 GetRecords
+	// calls and 2 MB of reads a second, shared by every application reading it.
+	//
+	// See http://docs.aws.amazon.com/streams/latest/dev/service-sizes-and-limits.html
+	maxGetRecordsPerSecond = 5
+	maxReadBytesPerSecond  = 2 << 20
+
+	// DefaultThrottleFarBehind is how far behind the tip of a shard its reader has to be for an
+	// AdaptiveThrottle to speed up towards the shard's read limits.
+	DefaultThrottleFarBehind = 10 * time.Second
+
+	// maxThrottleInterval caps how far an AdaptiveThrottle backs off a shard that keeps being
+	// throttled.
+	maxThrottleInterval = 10 * time.Second
+)
+
+type ThrottleOptions struct {
+	// How long to wait between GetRecords calls on a shard whose reader is caught up. The zero
+	// value is DefaultGetRecordsThrottle. It is never less than the shard's read limits allow, so
+	// readers that are far behind are only sped up if it is longer than that: 200ms for one
+	// application, which is DefaultGetRecordsThrottle.
+	Interval time.Duration
+
+	// How many applications read the stream, which share each shard's read limits. The zero value
+	// is 1.
+	Applications int
+
+	// How far behind the tip of a shard its reader has to be to speed up towards the shard's read
+	// limits. The zero value is DefaultThrottleFarBehind.
+	FarBehind time.Duration
+}
+
+// AdaptiveThrottle paces each shard's GetRecords calls separately. It backs off exponentially,
+// with jitter, while a shard is throttled, speeds up towards the shard's read limits while its
+// reader is far behind, and otherwise waits Interval between calls. A call that read a lot of data
+// is followed by a long enough wait to keep the shard under its read rate.
+type AdaptiveThrottle struct {
+	interval    time.Duration
+	minInterval time.Duration
+	byteRate    float64
+	farBehind   time.Duration
+
+	mut    sync.Mutex
+	shards map[string]*shardThrottle
+	rand   *rand.Rand
+}
+
+type shardThrottle struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func NewAdaptiveThrottle(opt *ThrottleOptions) *AdaptiveThrottle {
+	if opt == nil {
+		opt = &ThrottleOptions{}
+	}
+	applications := opt.Applications
+	if applications <= 0 {
+		applications = 1
+	}
+	farBehind := opt.FarBehind
+	if farBehind == 0 {
+		farBehind = DefaultThrottleFarBehind
+	}
+
+	minInterval := time.Duration(applications) * time.Second / maxGetRecordsPerSecond
+	interval := opt.Interval
+	if interval == 0 {
+		interval = DefaultGetRecordsThrottle
+	}
+	if interval < minInterval {
+		interval = minInterval
+	}
+
+	return &AdaptiveThrottle{
+		interval:    interval,
+		minInterval: minInterval,
+		byteRate:    float64(maxReadBytesPerSecond) / float64(applications),
+		farBehind:   farBehind,
+		shards:      make(map[string]*shardThrottle),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (t *AdaptiveThrottle) Wait(ctx context.Context, shardID string) error {
+	t.mut.Lock()
+	wait := time.Until(t.shard(shardID).next)
+	t.mut.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *AdaptiveThrottle) Observe(shardID string, bytes int, millisBehindLatest int64, throttled bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	s := t.shard(shardID)
+	now := time.Now()
+
+	switch {
+	case throttled:
+		if s.interval *= 2; s.interval > maxThrottleInterval {
+			s.interval = maxThrottleInterval
+		}
+		// Jitter keeps the applications sharing the shard from retrying in step.
+		s.next = now.Add(s.interval/2 + time.Duration(t.rand.Int63n(int64(s.interval/2)+1)))
+		return
+	case time.Duration(millisBehindLatest)*time.Millisecond >= t.farBehind:
+		if s.interval /= 2; s.interval < t.minInterval {
+			s.interval = t.minInterval
+		}
+	case s.interval > t.interval:
+		if s.interval /= 2; s.interval < t.interval {
+			s.interval = t.interval
+		}
+	default:
+		s.interval = t.interval
+	}
+
+	wait := s.interval
+	if read := time.Duration(float64(bytes) / t.byteRate * float64(time.Second)); read > wait {
+		wait = read
+	}
+	s.next = now.Add(wait)
+}
+
+// ShardReleased drops the shard's state, so that the shards that a consumer has owned over time
+// don't pile up.
+func (t *AdaptiveThrottle) ShardReleased(shardID string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	delete(t.shards, shardID)
+}
+
+// shard returns a shard's state, which starts at the configured interval.
+func (t *AdaptiveThrottle) shard(shardID string) *shardThrottle {
+	s := t.shards[shardID]
+	if s == nil {
+		s = &shardThrottle{interval: t.interval}
+		t.shards[shardID] = s
+	}
+	return s
+}
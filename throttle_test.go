@@ -0,0 +1,77 @@
+package kinesumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveThrottleBackoff(t *testing.T) {
+	throttle := NewAdaptiveThrottle(nil)
+
+	for _, interval := range []time.Duration{400, 800, 1600} {
+		before := time.Now()
+		throttle.Observe("shard0", 0, 0, true)
+		s := throttle.shards["shard0"]
+		assert.Equal(t, interval*time.Millisecond, s.interval)
+		assert.True(t, s.next.Sub(before) >= s.interval/2)
+		assert.True(t, s.next.Sub(before) <= s.interval+time.Millisecond)
+	}
+
+	for i := 0; i < 10; i++ {
+		throttle.Observe("shard0", 0, 0, true)
+	}
+	assert.Equal(t, maxThrottleInterval, throttle.shards["shard0"].interval)
+
+	// Once calls go through again the interval returns to normal.
+	for i := 0; i < 10; i++ {
+		throttle.Observe("shard0", 0, 0, false)
+	}
+	assert.Equal(t, DefaultGetRecordsThrottle, throttle.shards["shard0"].interval)
+}
+
+func TestAdaptiveThrottleFarBehind(t *testing.T) {
+	throttle := NewAdaptiveThrottle(&ThrottleOptions{Interval: time.Second, Applications: 2})
+
+	throttle.Observe("shard0", 0, 60000, false)
+	assert.Equal(t, 500*time.Millisecond, throttle.shards["shard0"].interval)
+	throttle.Observe("shard0", 0, 60000, false)
+	// Two applications share the shard's 5 calls a second.
+	assert.Equal(t, 400*time.Millisecond, throttle.shards["shard0"].interval)
+
+	throttle.Observe("shard0", 0, 0, false)
+	assert.Equal(t, time.Second, throttle.shards["shard0"].interval)
+}
+
+func TestAdaptiveThrottleBytes(t *testing.T) {
+	throttle := NewAdaptiveThrottle(nil)
+
+	before := time.Now()
+	throttle.Observe("shard0", maxReadBytesPerSecond, 60000, false)
+	assert.True(t, throttle.shards["shard0"].next.Sub(before) >= time.Second)
+	assert.Equal(t, DefaultGetRecordsThrottle, throttle.shards["shard0"].interval)
+}
+
+func TestAdaptiveThrottleShardReleased(t *testing.T) {
+	throttle := NewAdaptiveThrottle(&ThrottleOptions{Interval: time.Hour})
+
+	throttle.Observe("shard0", 0, 0, true)
+	throttle.Observe("shard1", 0, 0, false)
+	throttle.ShardReleased("shard0")
+	assert.Nil(t, throttle.shards["shard0"])
+	assert.NotNil(t, throttle.shards["shard1"])
+}
+
+func TestAdaptiveThrottleWait(t *testing.T) {
+	throttle := NewAdaptiveThrottle(&ThrottleOptions{Interval: time.Hour})
+	assert.Nil(t, throttle.Wait(context.Background(), "shard0"))
+
+	throttle.Observe("shard0", 0, 0, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, throttle.Wait(ctx, "shard0"))
+	// Other shards aren't held up.
+	assert.Nil(t, throttle.Wait(context.Background(), "shard1"))
+}
@@ -0,0 +1,68 @@
+package kinesumer
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pborman/uuid"
+)
+
+// Writer provides an io.Writer implementation that writes data to a kinesis stream through a
+// Producer, one record per line. The newline is kept at the end of each record, so that the output
+// of a Reader on a stream of lines can be written back to a stream unchanged.
+type Writer struct {
+	producer *Producer
+
+	// PartitionKey returns the partition key of a record. The zero value gives each record a
+	// random key, spreading records across the stream's shards.
+	PartitionKey func(data []byte) string
+
+	// buffered data for a line that hasn't been ended yet.
+	buf []byte
+}
+
+// NewWriter returns a new Writer instance that writes data to producer.
+func NewWriter(producer *Producer) *Writer {
+	return &Writer{producer: producer}
+}
+
+// Write implements io.Writer Write. Each line in b is put as a record, and whatever follows the last
+// newline is buffered until the line is ended or the Writer is closed.
+func (w *Writer) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			w.buf = append(w.buf, b...)
+			return n + len(b), nil
+		}
+
+		line := append(w.buf, b[:i+1]...)
+		w.buf = nil
+		if err := w.put(line); err != nil {
+			return n, err
+		}
+		n += i + 1
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// Close puts the line that hasn't been ended, if there is one, and flushes the Producer.
+func (w *Writer) Close() error {
+	if len(w.buf) > 0 {
+		line := w.buf
+		w.buf = nil
+		if err := w.put(line); err != nil {
+			return err
+		}
+	}
+	return w.producer.Flush(context.Background())
+}
+
+func (w *Writer) put(data []byte) error {
+	key := uuid.New()
+	if w.PartitionKey != nil {
+		key = w.PartitionKey(data)
+	}
+	return w.producer.Put(context.Background(), data, key)
+}
@@ -0,0 +1,47 @@
+package kinesumer
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter_Write(t *testing.T) {
+	p, _, inputs := makeTestProducer(t)
+	w := NewWriter(p)
+	w.PartitionKey = func(data []byte) string { return string(data[:1]) }
+
+	n, err := w.Write([]byte("a\nb"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = w.Write([]byte("c\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = io.Copy(w, strings.NewReader("d"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*inputs))
+
+	assert.NoError(t, w.Close())
+	records := (*inputs)[0].Records
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, []byte("a\n"), records[0].Data)
+	assert.Equal(t, []byte("bc\n"), records[1].Data)
+	assert.Equal(t, []byte("d"), records[2].Data)
+	assert.Equal(t, "b", aws.ToString(records[1].PartitionKey))
+}
+
+func TestWriter_Write_Copy(t *testing.T) {
+	p, _, inputs := makeTestProducer(t)
+	w := NewWriter(p)
+
+	// The Writer mustn't hold on to the slices it is given.
+	b := []byte("a\nb")
+	w.Write(b)
+	copy(b, "xxx")
+	w.Close()
+	assert.Equal(t, []byte("a\n"), (*inputs)[0].Records[0].Data)
+	assert.Equal(t, []byte("b"), (*inputs)[0].Records[1].Data)
+}